@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -12,6 +11,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/centrifugal/centrifuge-go/jwt"
 	"github.com/centrifugal/protocol"
 )
 
@@ -36,13 +36,21 @@ type Client struct {
 	futureID          uint64
 	cmdID             uint32
 	mu                sync.RWMutex
-	endpoints         []string
-	round             int
+	transports        []*transportState
+	transportIndex    int
+	maxTransportFails int
 	protocolType      protocol.Type
 	config            Config
-	token             string
-	data              protocol.Raw
-	transport         transport
+	// httpClient is shared by HTTP-based transports, so connections to a
+	// server are reused between reconnects.
+	httpClient *http.Client
+	token      string
+	data       protocol.Raw
+	transport  Transport
+	// writerMu guards writer separately from mu as send is called with mu
+	// held.
+	writerMu          sync.Mutex
+	writer            *writer
 	state             State
 	subs              map[string]*Subscription
 	serverSubs        map[string]*serverSub
@@ -50,7 +58,9 @@ type Client struct {
 	requests          map[uint32]request
 	receive           chan []byte
 	reconnectAttempts int
-	reconnectStrategy reconnectStrategy
+	reconnectStarted  time.Time
+	retryAfter        time.Duration
+	reconnectStrategy ReconnectStrategy
 	events            *eventHub
 	sendPong          bool
 	paramsEncoder     protocol.ParamsEncoder
@@ -60,7 +70,11 @@ type Client struct {
 	pushDecoder       protocol.PushDecoder
 	delayPing         chan struct{}
 	closeCh           chan struct{}
+	ctx               context.Context
+	ctxCancel         context.CancelFunc
 	connectFutures    map[uint64]connectFuture
+	configErr         error
+	subTokens         *subscriptionTokenCache
 	cbQueue           *cbQueue
 	reconnectTimer    *time.Timer
 	refreshTimer      *time.Timer
@@ -70,18 +84,26 @@ type Client struct {
 // NewJsonClient initializes Client which uses JSON-based protocol internally.
 // After client initialized call Client.Connect method.
 // Use Client.NewSubscription to create Subscription objects.
+// Malformed endpoint or invalid config error is returned from Client.Connect,
+// use NewClient to get it right away.
 func NewJsonClient(endpoint string, config Config) *Client {
-	return newClient(endpoint, false, config)
+	client, _ := newClient(endpoint, protocol.TypeJSON, config)
+	return client
 }
 
 // NewProtobufClient initializes Client which uses Protobuf-based protocol internally.
 // After client initialized call Client.Connect method.
 // Use Client.NewSubscription to create Subscription objects.
+// Malformed endpoint or invalid config error is returned from Client.Connect,
+// use NewClient to get it right away.
 func NewProtobufClient(endpoint string, config Config) *Client {
-	return newClient(endpoint, true, config)
+	client, _ := newClient(endpoint, protocol.TypeProtobuf, config)
+	return client
 }
 
-func newClient(endpoint string, isProtobuf bool, config Config) *Client {
+// newClient always returns usable Client, config error is also kept in
+// Client to be returned from Connect.
+func newClient(endpoint string, protocolType protocol.Type, config Config) (*Client, error) {
 	if config.ReadTimeout == 0 {
 		config.ReadTimeout = 5 * time.Second
 	}
@@ -100,36 +122,29 @@ func newClient(endpoint string, isProtobuf bool, config Config) *Client {
 	if config.Name == "" {
 		config.Name = "go"
 	}
-	// We support setting multiple endpoints to try in round-robin fashion. But
-	// for now this feature is not documented and used for internal tests. In most
-	// cases there should be a single public server WS endpoint.
-	endpoints := strings.Split(endpoint, ",")
-	if len(endpoints) == 0 {
-		panic("connection endpoint required")
+	if config.ReconnectStrategy == nil {
+		config.ReconnectStrategy = defaultBackoffReconnect
 	}
-	rand.Shuffle(len(endpoints), func(i, j int) {
-		endpoints[i], endpoints[j] = endpoints[j], endpoints[i]
-	})
-	for _, e := range endpoints {
-		if !strings.HasPrefix(e, "ws") {
-			panic(fmt.Sprintf("unsupported connection endpoint: %s", e))
-		}
+	if config.OutboundQueueSize == 0 {
+		config.OutboundQueueSize = 1024
 	}
-
-	protocolType := protocol.TypeJSON
-	if isProtobuf {
-		protocolType = protocol.TypeProtobuf
+	configErr := config.Validate()
+	transports, maxTransportFails, err := buildTransports(endpoint, config)
+	if configErr == nil {
+		configErr = err
 	}
 
 	client := &Client{
-		endpoints:         endpoints,
+		transports:        transports,
+		maxTransportFails: maxTransportFails,
 		config:            config,
+		httpClient:        newHTTPClient(config),
 		state:             StateDisconnected,
 		protocolType:      protocolType,
 		subs:              make(map[string]*Subscription),
 		serverSubs:        make(map[string]*serverSub),
 		requests:          make(map[uint32]request),
-		reconnectStrategy: defaultBackoffReconnect,
+		reconnectStrategy: config.ReconnectStrategy,
 		paramsEncoder:     newParamsEncoder(protocolType),
 		resultDecoder:     newResultDecoder(protocolType),
 		commandEncoder:    newCommandEncoder(protocolType),
@@ -138,22 +153,93 @@ func newClient(endpoint string, isProtobuf bool, config Config) *Client {
 		delayPing:         make(chan struct{}, 32),
 		events:            newEventHub(),
 		connectFutures:    make(map[uint64]connectFuture),
+		configErr:         configErr,
+		subTokens:         newSubscriptionTokenCache(),
 	}
 	client.token = config.Token
 	client.data = config.Data
+	client.ctx, client.ctxCancel = context.WithCancel(context.Background())
 
 	// Queue to run callbacks on.
 	client.cbQueue = &cbQueue{}
 	client.cbQueue.cond = sync.NewCond(&client.cbQueue.mu)
 	go client.cbQueue.dispatch()
 
-	return client
+	return client, configErr
+}
+
+// transportState keeps connection health of a transport to connect with.
+type transportState struct {
+	TransportEndpoint
+	factory transportFactory
+	// failures is a number of consecutive failed connection attempts.
+	failures int
+}
+
+// buildTransports returns transports to connect with and a number of failed
+// connection attempts to fall back to the next transport after.
+func buildTransports(endpoint string, config Config) ([]*transportState, int, error) {
+	if len(config.Transports) > 0 {
+		transports := make([]*transportState, 0, len(config.Transports))
+		for _, te := range config.Transports {
+			var factory transportFactory
+			if te.Factory != nil {
+				factory = withoutOptions(te.Factory)
+			} else {
+				var ok bool
+				factory, ok = transportFactories[te.Transport]
+				if !ok {
+					return nil, 0, ConfigError{Field: "Transports", Reason: fmt.Sprintf("unsupported transport: %s", te.Transport)}
+				}
+			}
+			transports = append(transports, &transportState{TransportEndpoint: te, factory: factory})
+		}
+		maxFails := config.TransportMaxFailures
+		if maxFails == 0 {
+			maxFails = 3
+		}
+		return transports, maxFails, nil
+	}
+
+	// Endpoint format is only known for transports shipped with this package.
+	isCustomTransport := config.TransportFactory != nil
+	factory := newTransport
+	if isCustomTransport {
+		factory = withoutOptions(config.TransportFactory)
+	}
+	// We support setting multiple endpoints to try in round-robin fashion. But
+	// for now this feature is not documented and used for internal tests. In most
+	// cases there should be a single public server WS endpoint.
+	if endpoint == "" {
+		return nil, 0, ConfigError{Field: "endpoint", Reason: "connection endpoint required"}
+	}
+	endpoints := strings.Split(endpoint, ",")
+	rand.Shuffle(len(endpoints), func(i, j int) {
+		endpoints[i], endpoints[j] = endpoints[j], endpoints[i]
+	})
+	transports := make([]*transportState, 0, len(endpoints))
+	for _, e := range endpoints {
+		if !isCustomTransport {
+			if err := validateEndpoint(e); err != nil {
+				return nil, 0, ConfigError{Field: "endpoint", Reason: err.Error()}
+			}
+		}
+		transports = append(transports, &transportState{
+			TransportEndpoint: TransportEndpoint{Transport: transportTypeFromEndpoint(e), Endpoint: e},
+			factory:           factory,
+		})
+	}
+	// Switch endpoint after every failed attempt.
+	return transports, 1, nil
 }
 
 // Connect dials to server and sends connect message. Will return an error if first
 // dial with a server failed. In case of failure client will automatically reconnect.
 // To temporary disconnect from a server call Client.Disconnect.
 func (c *Client) Connect() error {
+	if c.configErr != nil {
+		return c.configErr
+	}
 	return c.startConnecting()
 }
 
@@ -197,6 +283,11 @@ func (c *Client) NewSubscription(channel string, config ...SubscriptionConfig) (
 	if _, ok := c.subs[channel]; ok {
 		return nil, ErrDuplicateSubscription
 	}
+	if len(config) > 0 {
+		if err := config[0].Validate(); err != nil {
+			return nil, err
+		}
+	}
 	sub = newSubscription(c, channel, config...)
 	c.subs[channel] = sub
 	return sub, nil
@@ -209,8 +300,11 @@ func (c *Client) RemoveSubscription(sub *Subscription) error {
 		return errors.New("subscription must be unsubscribed to be removed")
 	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	delete(c.subs, sub.Channel)
+	c.subTokens.delete(sub.Channel)
+	c.mu.Unlock()
+	// Closed on callback queue after publications already queued delivered.
+	c.runHandlerAsync(sub.closePublicationChans)
 	return nil
 }
 
@@ -367,6 +461,7 @@ func (c *Client) moveToDisconnected(code uint32, reason string) {
 		_ = c.transport.Close()
 		c.transport = nil
 	}
+	c.swapWriter(nil)
 
 	prevState := c.state
 	c.state = StateDisconnected
@@ -395,8 +490,8 @@ func (c *Client) moveToDisconnected(code uint32, reason string) {
 
 	if prevState == StateConnected {
 		var serverSubscribingHandler ServerSubscribingHandler
-		if c.events != nil && c.events.onServerSubscribing != nil {
-			serverSubscribingHandler = c.events.onServerSubscribing
+		if events := c.events.load(); events.onServerSubscribing != nil {
+			serverSubscribingHandler = events.onServerSubscribing
 		}
 		if serverSubscribingHandler != nil {
 			c.runHandlerAsync(func() {
@@ -407,9 +502,14 @@ func (c *Client) moveToDisconnected(code uint32, reason string) {
 		}
 	}
 
+	if prevState == StateConnected {
+		// Leaving StateConnecting already observed in moveToConnecting.
+		c.observeDisconnected(code, reason)
+	}
+
 	var handler DisconnectHandler
-	if c.events != nil && c.events.onDisconnected != nil {
-		handler = c.events.onDisconnected
+	if events := c.events.load(); events.onDisconnected != nil {
+		handler = events.onDisconnected
 	}
 	if handler != nil {
 		c.runHandlerAsync(func() {
@@ -429,8 +529,10 @@ func (c *Client) moveToConnecting(code uint32, reason string) {
 		_ = c.transport.Close()
 		c.transport = nil
 	}
+	c.swapWriter(nil)
 
 	c.state = StateConnecting
+	c.reconnectStarted = time.Now()
 	c.clearConnectedState()
 	c.resolveConnectFutures(ErrClientDisconnected)
 
@@ -455,8 +557,8 @@ func (c *Client) moveToConnecting(code uint32, reason string) {
 	}
 
 	var serverSubscribingHandler ServerSubscribingHandler
-	if c.events != nil && c.events.onServerSubscribing != nil {
-		serverSubscribingHandler = c.events.onServerSubscribing
+	if events := c.events.load(); events.onServerSubscribing != nil {
+		serverSubscribingHandler = events.onServerSubscribing
 	}
 	if serverSubscribingHandler != nil {
 		c.runHandlerSync(func() {
@@ -466,9 +568,11 @@ func (c *Client) moveToConnecting(code uint32, reason string) {
 		})
 	}
 
+	c.observeDisconnected(code, reason)
+
 	var handler ConnectingHandler
-	if c.events != nil && c.events.onConnecting != nil {
-		handler = c.events.onConnecting
+	if events := c.events.load(); events.onConnecting != nil {
+		handler = events.onConnecting
 	}
 	if handler != nil {
 		c.runHandlerSync(func() {
@@ -482,11 +586,7 @@ func (c *Client) moveToConnecting(code uint32, reason string) {
 		c.mu.Unlock()
 		return
 	}
-	c.reconnectAttempts++
-	reconnectDelay := c.getReconnectDelay()
-	c.reconnectTimer = time.AfterFunc(reconnectDelay, func() {
-		_ = c.startReconnecting()
-	})
+	c.scheduleReconnect()
 	c.mu.Unlock()
 }
 
@@ -497,6 +597,8 @@ func (c *Client) moveToClosed() {
 		return
 	}
 	c.state = StateClosed
+	c.ctxCancel()
+	c.httpClient.CloseIdleConnections()
 
 	subsToUnsubscribe := make([]*Subscription, 0, len(c.subs))
 	for _, s := range c.subs {
@@ -519,8 +621,8 @@ func (c *Client) moveToClosed() {
 	}
 
 	var serverUnsubscribedHandler ServerUnsubscribedHandler
-	if c.events != nil && c.events.onServerUnsubscribed != nil {
-		serverUnsubscribedHandler = c.events.onServerUnsubscribed
+	if events := c.events.load(); events.onServerUnsubscribed != nil {
+		serverUnsubscribedHandler = events.onServerUnsubscribed
 	}
 	if serverUnsubscribedHandler != nil {
 		c.runHandlerAsync(func() {
@@ -530,16 +632,25 @@ func (c *Client) moveToClosed() {
 		})
 	}
 
-	c.mu.Lock()
+	c.flushPositions()
+
+	c.mu.RLock()
+	subs := make([]*Subscription, 0, len(c.subs))
+	for _, s := range c.subs {
+		subs = append(subs, s)
+	}
+	c.mu.RUnlock()
+	c.runHandlerAsync(func() {
+		c.closeEventChans(subs)
+	})
 	c.cbQueue.close()
-	c.cbQueue = nil
-	c.mu.Unlock()
 }
 
 func (c *Client) handleError(err error) {
+	c.observeError(err)
 	var handler ErrorHandler
-	if c.events != nil && c.events.onError != nil {
-		handler = c.events.onError
+	if events := c.events.load(); events.onError != nil {
+		handler = events.onError
 	}
 	if handler != nil {
 		c.runHandlerSync(func() {
@@ -548,6 +659,22 @@ func (c *Client) handleError(err error) {
 	}
 }
 
+// handleErrorAsync emits error event without waiting for handler, so it can
+// be called from inside other handlers and with lock held. Logger and Metrics
+// also called from callback queue as they may call Client methods.
+func (c *Client) handleErrorAsync(err error) {
+	var handler ErrorHandler
+	if events := c.events.load(); events.onError != nil {
+		handler = events.onError
+	}
+	c.runHandlerAsync(func() {
+		c.observeError(err)
+		if handler != nil {
+			handler(ErrorEvent{Error: err})
+		}
+	})
+}
+
 // Lock must be held outside.
 func (c *Client) clearConnectedState() {
 	if c.reconnectTimer != nil {
@@ -578,9 +705,9 @@ func (c *Client) clearConnectedState() {
 	}
 }
 
-func (c *Client) handleDisconnect(d *disconnect) {
+func (c *Client) handleDisconnect(d *Disconnect) {
 	if d == nil {
-		d = &disconnect{
+		d = &Disconnect{
 			Code:      connectingTransportClosed,
 			Reason:    "transport closed",
 			Reconnect: true,
@@ -599,14 +726,14 @@ func (c *Client) waitServerPing(disconnectCh chan struct{}, pingInterval uint32)
 		select {
 		case <-c.delayPing:
 		case <-time.After(timeout):
-			go c.handleDisconnect(&disconnect{Code: connectingNoPing, Reason: "no ping", Reconnect: true})
+			go c.handleDisconnect(&Disconnect{Code: connectingNoPing, Reason: "no ping", Reconnect: true})
 		case <-disconnectCh:
 			return
 		}
 	}
 }
 
-func (c *Client) readOnce(t transport) error {
+func (c *Client) readOnce(t Transport) error {
 	reply, disconnect, err := t.Read()
 	if err != nil {
 		go c.handleDisconnect(disconnect)
@@ -616,7 +743,7 @@ func (c *Client) readOnce(t transport) error {
 	return nil
 }
 
-func (c *Client) reader(t transport, disconnectCh chan struct{}) {
+func (c *Client) reader(t Transport, disconnectCh chan struct{}) {
 	defer close(disconnectCh)
 	for {
 		err := c.readOnce(t)
@@ -628,15 +755,19 @@ func (c *Client) reader(t transport, disconnectCh chan struct{}) {
 
 func (c *Client) runHandlerSync(fn func()) {
 	waitCh := make(chan struct{})
-	c.cbQueue.push(func(delay time.Duration) {
+	ok := c.cbQueue.push(func(delay time.Duration) {
 		defer close(waitCh)
 		fn()
 	})
+	if !ok {
+		// Client closed.
+		return
+	}
 	<-waitCh
 }
 
 func (c *Client) runHandlerAsync(fn func()) {
-	c.cbQueue.push(func(delay time.Duration) {
+	_ = c.cbQueue.push(func(delay time.Duration) {
 		fn()
 	})
 }
@@ -680,8 +811,8 @@ func (c *Client) handle(reply *protocol.Reply) {
 
 func (c *Client) handleMessage(msg *protocol.Message) error {
 	var handler MessageHandler
-	if c.events != nil && c.events.onMessage != nil {
-		handler = c.events.onMessage
+	if events := c.events.load(); events.onMessage != nil {
+		handler = events.onMessage
 	}
 	if handler != nil {
 		event := MessageEvent{Data: msg.Data}
@@ -756,18 +887,26 @@ func (c *Client) handleServerPublication(channel string, pub *protocol.Publicati
 		c.mu.Unlock()
 		return
 	}
-	if serverSub.Recoverable && pub.Offset > 0 {
+	savePos := serverSub.Recoverable && pub.Offset > 0
+	if savePos {
 		serverSub.Offset = pub.Offset
 	}
+	pos := StreamPosition{Offset: serverSub.Offset, Epoch: serverSub.Epoch}
 	c.mu.Unlock()
 
 	var handler ServerPublicationHandler
-	if c.events != nil && c.events.onServerPublication != nil {
-		handler = c.events.onServerPublication
+	if events := c.events.load(); events.onServerPublication != nil {
+		handler = events.onServerPublication
 	}
-	if handler != nil {
+	if handler != nil || savePos {
 		c.runHandlerSync(func() {
-			handler(ServerPublicationEvent{Channel: channel, Publication: pubFromProto(pub)})
+			if handler != nil {
+				handler(ServerPublicationEvent{Channel: channel, Publication: pubFromProto(pub)})
+			}
+			// Position saved after publication processed.
+			if savePos {
+				c.savePosition(channel, pos)
+			}
 		})
 	}
 }
@@ -782,8 +921,8 @@ func (c *Client) handleServerJoin(channel string, join *protocol.Join) {
 	c.mu.Unlock()
 
 	var handler ServerJoinHandler
-	if c.events != nil && c.events.onServerJoin != nil {
-		handler = c.events.onServerJoin
+	if events := c.events.load(); events.onServerJoin != nil {
+		handler = events.onServerJoin
 	}
 	if handler != nil {
 		c.runHandlerSync(func() {
@@ -802,8 +941,8 @@ func (c *Client) handleServerLeave(channel string, leave *protocol.Leave) {
 	c.mu.Unlock()
 
 	var handler ServerLeaveHandler
-	if c.events != nil && c.events.onServerLeave != nil {
-		handler = c.events.onServerLeave
+	if events := c.events.load(); events.onServerLeave != nil {
+		handler = events.onServerLeave
 	}
 	if handler != nil {
 		c.runHandlerSync(func() {
@@ -826,9 +965,13 @@ func (c *Client) handleServerSub(channel string, sub *protocol.Subscribe) {
 	}
 	c.mu.Unlock()
 
+	if sub.Recoverable {
+		c.savePosition(channel, StreamPosition{Offset: sub.Offset, Epoch: sub.Epoch})
+	}
+
 	var handler ServerSubscribedHandler
-	if c.events != nil && c.events.onServerSubscribe != nil {
-		handler = c.events.onServerSubscribe
+	if events := c.events.load(); events.onServerSubscribe != nil {
+		handler = events.onServerSubscribe
 	}
 	if handler != nil {
 		c.runHandlerSync(func() {
@@ -861,8 +1004,8 @@ func (c *Client) handleServerUnsub(channel string, _ *protocol.Unsubscribe) {
 	}
 
 	var handler ServerUnsubscribedHandler
-	if c.events != nil && c.events.onServerUnsubscribed != nil {
-		handler = c.events.onServerUnsubscribed
+	if events := c.events.load(); events.onServerUnsubscribed != nil {
+		handler = events.onServerUnsubscribed
 	}
 	if handler != nil {
 		c.runHandlerSync(func() {
@@ -871,46 +1014,61 @@ func (c *Client) handleServerUnsub(channel string, _ *protocol.Unsubscribe) {
 	}
 }
 
-func (c *Client) getReconnectDelay() time.Duration {
-	return c.reconnectStrategy.timeBeforeNextAttempt(c.reconnectAttempts)
+// setRetryAfter remembers delay server asked to wait before next connection
+// attempt. Lock must be held outside.
+func (c *Client) setRetryAfter(err error) {
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		c.retryAfter = retryAfterErr.RetryAfter
+	}
+}
+
+// scheduleReconnect schedules next connection attempt according to reconnect
+// strategy. Lock must be held outside.
+func (c *Client) scheduleReconnect() {
+	c.reconnectAttempts++
+	if c.config.MaxReconnectAttempts > 0 && c.reconnectAttempts > c.config.MaxReconnectAttempts {
+		go c.moveToDisconnected(disconnectedMaxAttempts, "max reconnect attempts reached")
+		return
+	}
+	reconnectDelay, ok := c.reconnectStrategy.TimeBeforeNextAttempt(c.reconnectAttempts)
+	if !ok {
+		go c.moveToDisconnected(disconnectedReconnectStopped, "reconnect stopped")
+		return
+	}
+	if c.retryAfter > reconnectDelay {
+		// Server asked to wait longer.
+		reconnectDelay = c.retryAfter
+	}
+	c.retryAfter = 0
+	if c.config.MaxReconnectDuration > 0 && time.Since(c.reconnectStarted)+reconnectDelay > c.config.MaxReconnectDuration {
+		go c.moveToDisconnected(disconnectedMaxDuration, "max reconnect duration reached")
+		return
+	}
+	c.reconnectTimer = time.AfterFunc(reconnectDelay, func() {
+		_ = c.startReconnecting()
+	})
 }
 
 func (c *Client) startReconnecting() error {
 	c.mu.Lock()
-	c.round++
-	round := c.round
 	if c.state != StateConnecting {
 		c.mu.Unlock()
 		return nil
 	}
 	refreshRequired := c.refreshRequired
+	expiresAt, expiring := c.tokenExpiring()
+	ts := c.transports[c.transportIndex]
 	c.mu.Unlock()
 
-	wsConfig := websocketConfig{
-		NetDialContext:    c.config.NetDialContext,
-		TLSConfig:         c.config.TLSConfig,
-		HandshakeTimeout:  c.config.HandshakeTimeout,
-		EnableCompression: c.config.EnableCompression,
-		CookieJar:         c.config.CookieJar,
-		Header:            c.config.Header,
-	}
+	c.observeConnectAttempt()
 
-	u := c.endpoints[round%len(c.endpoints)]
-	t, err := newWebsocketTransport(u, c.protocolType, wsConfig)
-	if err != nil {
-		c.handleError(TransportError{err})
-		c.mu.Lock()
-		if c.state != StateConnecting {
-			c.mu.Unlock()
-			return nil
+	if expiring {
+		if c.config.GetToken != nil {
+			refreshRequired = true
+		} else {
+			c.handleError(TokenExpiringError{ExpiresAt: expiresAt})
 		}
-		c.reconnectAttempts++
-		reconnectDelay := c.getReconnectDelay()
-		c.reconnectTimer = time.AfterFunc(reconnectDelay, func() {
-			_ = c.startReconnecting()
-		})
-		c.mu.Unlock()
-		return err
 	}
 
 	if refreshRequired {
@@ -920,15 +1078,10 @@ func (c *Client) startReconnecting() error {
 			c.handleError(RefreshError{err})
 			c.mu.Lock()
 			if c.state != StateConnecting {
-				_ = t.Close()
 				c.mu.Unlock()
 				return nil
 			}
-			c.reconnectAttempts++
-			reconnectDelay := c.getReconnectDelay()
-			c.reconnectTimer = time.AfterFunc(reconnectDelay, func() {
-				_ = c.startReconnecting()
-			})
+			c.scheduleReconnect()
 			c.mu.Unlock()
 			return err
 		} else {
@@ -946,6 +1099,33 @@ func (c *Client) startReconnecting() error {
 		}
 	}
 
+	c.mu.Lock()
+	if c.state != StateConnecting {
+		c.mu.Unlock()
+		return nil
+	}
+	cmd := c.connectCommand()
+	opts := transportOptions{httpClient: c.httpClient, connectCommand: cmd}
+	config := c.config
+	c.mu.Unlock()
+
+	// Transport is created outside of lock: transports establish connection
+	// here, HTTP-based ones send connect command while opening stream.
+	t, err := ts.factory(ts.Endpoint, c.protocolType, config, opts)
+	if err != nil {
+		c.handleError(TransportError{err})
+		c.mu.Lock()
+		if c.state != StateConnecting {
+			c.mu.Unlock()
+			return nil
+		}
+		c.handleTransportFailure(ts)
+		c.setRetryAfter(err)
+		c.scheduleReconnect()
+		c.mu.Unlock()
+		return err
+	}
+
 	c.mu.Lock()
 	if c.state != StateConnecting {
 		_ = t.Close()
@@ -956,10 +1136,12 @@ func (c *Client) startReconnecting() error {
 	disconnectCh := make(chan struct{})
 	c.receive = make(chan []byte, 64)
 	c.transport = t
+	// Writer of previous failed connection attempt is closed here.
+	c.swapWriter(newWriter(t, c.config.OutboundQueueSize, c.config.OutboundQueueOverflow, c.config.WriteTimeout, func(err error) {
+		go c.handleDisconnect(&Disconnect{Code: connectingTransportClosed, Reason: "write error", Reconnect: true})
+	}))
 
-	go c.reader(t, disconnectCh)
-
-	err = c.sendConnect(func(res *protocol.ConnectResult, err error) {
+	err = c.sendConnect(cmd, func(res *protocol.ConnectResult, err error) {
 		c.mu.Lock()
 		if c.state != StateConnecting {
 			c.mu.Unlock()
@@ -975,11 +1157,7 @@ func (c *Client) startReconnecting() error {
 					return
 				}
 				c.refreshRequired = true
-				c.reconnectAttempts++
-				reconnectDelay := c.getReconnectDelay()
-				c.reconnectTimer = time.AfterFunc(reconnectDelay, func() {
-					_ = c.startReconnecting()
-				})
+				c.scheduleReconnect()
 				return
 			} else if isServerError(err) && !isTemporaryError(err) {
 				var serverError *Error
@@ -994,11 +1172,8 @@ func (c *Client) startReconnecting() error {
 					_ = t.Close()
 					return
 				}
-				c.reconnectAttempts++
-				reconnectDelay := c.getReconnectDelay()
-				c.reconnectTimer = time.AfterFunc(reconnectDelay, func() {
-					_ = c.startReconnecting()
-				})
+				c.handleTransportFailure(ts)
+				c.scheduleReconnect()
 				return
 			}
 		}
@@ -1009,15 +1184,18 @@ func (c *Client) startReconnecting() error {
 			return
 		}
 		c.state = StateConnected
+		ts.failures = 0
+		c.subTokens.setClient(res.Client)
 
 		if res.Expires {
-			c.refreshTimer = time.AfterFunc(time.Duration(res.Ttl)*time.Second, c.sendRefresh)
+			c.refreshTimer = time.AfterFunc(c.refreshDelay(res.Ttl), c.sendRefresh)
 		}
 		c.resolveConnectFutures(nil)
 		c.mu.Unlock()
+		c.observeConnected()
 
-		if c.events != nil && c.events.onConnected != nil {
-			handler := c.events.onConnected
+		if events := c.events.load(); events.onConnected != nil {
+			handler := events.onConnected
 			ev := ConnectedEvent{
 				ClientID: res.Client,
 				Version:  res.Version,
@@ -1029,13 +1207,13 @@ func (c *Client) startReconnecting() error {
 		}
 
 		var subscribeHandler ServerSubscribedHandler
-		if c.events != nil && c.events.onServerSubscribe != nil {
-			subscribeHandler = c.events.onServerSubscribe
+		if events := c.events.load(); events.onServerSubscribe != nil {
+			subscribeHandler = events.onServerSubscribe
 		}
 
 		var publishHandler ServerPublicationHandler
-		if c.events != nil && c.events.onServerPublication != nil {
-			publishHandler = c.events.onServerPublication
+		if events := c.events.load(); events.onServerPublication != nil {
+			publishHandler = events.onServerPublication
 		}
 
 		for channel, subRes := range res.Subs {
@@ -1055,8 +1233,13 @@ func (c *Client) startReconnecting() error {
 				sub.Offset = subRes.Offset
 			}
 			c.serverSubs[channel] = sub
+			pos := StreamPosition{Offset: sub.Offset, Epoch: sub.Epoch}
 			c.mu.Unlock()
 
+			if subRes.Recoverable && len(subRes.Publications) == 0 {
+				c.savePosition(channel, pos)
+			}
+
 			if subscribeHandler != nil {
 				c.runHandlerSync(func() {
 					ev := ServerSubscribedEvent{
@@ -1084,8 +1267,13 @@ func (c *Client) startReconnecting() error {
 							sub.Offset = pub.Offset
 						}
 						c.serverSubs[channel] = sub
+						pos := StreamPosition{Offset: sub.Offset, Epoch: sub.Epoch}
 						c.mu.Unlock()
 						publishHandler(ServerPublicationEvent{Channel: channel, Publication: pubFromProto(pub)})
+						// Position saved after publication processed.
+						if subRes.Recoverable {
+							c.savePosition(channel, pos)
+						}
 					}
 				})
 			}
@@ -1094,8 +1282,8 @@ func (c *Client) startReconnecting() error {
 		for ch := range c.serverSubs {
 			if _, ok := res.Subs[ch]; !ok {
 				var serverUnsubscribedHandler ServerUnsubscribedHandler
-				if c.events != nil && c.events.onServerSubscribing != nil {
-					serverUnsubscribedHandler = c.events.onServerUnsubscribed
+				if events := c.events.load(); events.onServerSubscribing != nil {
+					serverUnsubscribedHandler = events.onServerUnsubscribed
 				}
 				if serverUnsubscribedHandler != nil {
 					c.runHandlerSync(func() {
@@ -1122,16 +1310,45 @@ func (c *Client) startReconnecting() error {
 	})
 	if err != nil {
 		_ = t.Close()
-		c.reconnectAttempts++
-		reconnectDelay := c.getReconnectDelay()
-		c.reconnectTimer = time.AfterFunc(reconnectDelay, func() {
-			_ = c.startReconnecting()
-		})
+		c.swapWriter(nil)
+		c.handleTransportFailure(ts)
+		c.setRetryAfter(err)
+		c.scheduleReconnect()
 		c.mu.Unlock()
 		c.handleError(ConnectError{err})
+		return err
 	}
+	// Reader started after connect request registered to not miss reply
+	// received over stream opened with transport.
+	go c.reader(t, disconnectCh)
 	c.mu.Unlock()
-	return err
+	return nil
+}
+
+// handleTransportFailure registers failed connection attempt over transport
+// and switches to the next transport when it failed too many times in a row.
+// Lock must be held outside.
+func (c *Client) handleTransportFailure(ts *transportState) {
+	if c.transports[c.transportIndex] != ts {
+		return
+	}
+	ts.failures++
+	if ts.failures < c.maxTransportFails || len(c.transports) == 1 {
+		return
+	}
+	ts.failures = 0
+	c.transportIndex = (c.transportIndex + 1) % len(c.transports)
+	next := c.transports[c.transportIndex]
+	if events := c.events.load(); events.onTransportSwitched != nil {
+		handler := events.onTransportSwitched
+		ev := TransportSwitchedEvent{
+			Transport:     next.TransportEndpoint,
+			PrevTransport: ts.TransportEndpoint,
+		}
+		c.runHandlerAsync(func() {
+			handler(ev)
+		})
+	}
 }
 
 func (c *Client) startConnecting() error {
@@ -1148,11 +1365,13 @@ func (c *Client) startConnecting() error {
 		c.closeCh = make(chan struct{})
 	}
 	c.state = StateConnecting
+	c.reconnectAttempts = 0
+	c.reconnectStarted = time.Now()
 	c.mu.Unlock()
 
 	var handler ConnectingHandler
-	if c.events != nil && c.events.onConnecting != nil {
-		handler = c.events.onConnecting
+	if events := c.events.load(); events.onConnecting != nil {
+		handler = events.onConnecting
 	}
 	if handler != nil {
 		c.runHandlerSync(func() {
@@ -1164,10 +1383,135 @@ func (c *Client) startConnecting() error {
 	return c.startReconnecting()
 }
 
+// resubscribe starts subscribing to all subscriptions in subscribing state
+// after connect. Lock must be held outside.
 func (c *Client) resubscribe() {
+	if len(c.subs) == 0 {
+		return
+	}
+	subs := make([]*Subscription, 0, len(c.subs))
 	for _, sub := range c.subs {
-		sub.resubscribe()
+		subs = append(subs, sub)
 	}
+	go c.resubscribeBatched(subs, c.transport)
+}
+
+// resubscribeBatched resubscribes subscriptions in batches of
+// ResubscribeBatchSize. Next batch is sent when all subscribe replies of the
+// previous one received and ResubscribeBatchInterval passed. It stops when
+// connection over transport t is lost.
+func (c *Client) resubscribeBatched(subs []*Subscription, t Transport) {
+	batchSize := c.config.ResubscribeBatchSize
+	if batchSize <= 0 {
+		batchSize = len(subs)
+	}
+	for i := 0; i < len(subs); i += batchSize {
+		if i > 0 && c.config.ResubscribeBatchInterval > 0 {
+			time.Sleep(c.config.ResubscribeBatchInterval)
+		}
+		if !c.isConnectedOver(t) {
+			return
+		}
+		end := i + batchSize
+		if end > len(subs) {
+			end = len(subs)
+		}
+		batch := subs[i:end]
+		tokens, oks := c.resubscribeTokens(batch)
+		if !c.isConnectedOver(t) {
+			return
+		}
+		var wg sync.WaitGroup
+		for j, sub := range batch {
+			if !oks[j] {
+				continue
+			}
+			wg.Add(1)
+			var once sync.Once
+			sub.sendResubscribe(tokens[j], func() {
+				once.Do(wg.Done)
+			})
+		}
+		wg.Wait()
+	}
+}
+
+// defaultResubscribeTokenConcurrency is a number of parallel subscription
+// token requests when Config.ResubscribeTokenConcurrency not set.
+const defaultResubscribeTokenConcurrency = 8
+
+// resubscribeTokens gets subscription tokens for subs in parallel using
+// ResubscribeTokenConcurrency workers.
+func (c *Client) resubscribeTokens(subs []*Subscription) ([]string, []bool) {
+	if c.config.GetSubscriptionTokens != nil {
+		c.prefetchSubscriptionTokens(subs)
+	}
+	tokens := make([]string, len(subs))
+	oks := make([]bool, len(subs))
+	workers := c.config.ResubscribeTokenConcurrency
+	if workers <= 0 {
+		workers = defaultResubscribeTokenConcurrency
+	}
+	if workers > len(subs) {
+		workers = len(subs)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				tokens[i], oks[i] = subs[i].resubscribeToken()
+			}
+		}()
+	}
+	for i := range subs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return tokens, oks
+}
+
+// prefetchSubscriptionTokens gets tokens for subs without cached tokens over
+// Config.GetSubscriptionTokens in one call and puts them to cache.
+func (c *Client) prefetchSubscriptionTokens(subs []*Subscription) {
+	var channels []string
+	for _, s := range subs {
+		if s.getToken == nil || s.State() != SubStateSubscribing {
+			continue
+		}
+		if _, ok := c.subTokens.get(s.Channel); ok {
+			continue
+		}
+		channels = append(channels, s.Channel)
+	}
+	if len(channels) == 0 {
+		return
+	}
+	ctx, cancel := c.tokenContext()
+	defer cancel()
+	tokens, err := c.config.GetSubscriptionTokens(SubscriptionTokensEvent{
+		Context:  ctx,
+		Channels: channels,
+	})
+	if err != nil {
+		// Subscriptions fall back to their own GetToken.
+		c.handleError(SubscriptionTokensError{err})
+		return
+	}
+	for _, ch := range channels {
+		if token, ok := tokens[ch]; ok && token != "" {
+			c.subTokens.set(ch, token, 0)
+		}
+	}
+}
+
+func (c *Client) isConnectedOver(t Transport) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state == StateConnected && c.transport == t
 }
 
 func isTokenExpiredError(err error) bool {
@@ -1191,12 +1535,69 @@ func isTemporaryError(err error) bool {
 	return false
 }
 
+// defaultTokenExpiryLead is a time before connection token expiration when
+// Config.TokenExpiryLead not set.
+const defaultTokenExpiryLead = 10 * time.Second
+
+// tokenExpiring checks exp claim of connection token if it's a JWT.
+// Lock must be held outside.
+func (c *Client) tokenExpiring() (time.Time, bool) {
+	if c.token == "" {
+		return time.Time{}, false
+	}
+	expiresAt, ok, err := jwt.ExpiresAt(c.token)
+	if err != nil || !ok {
+		// Not a JWT or token without expiration.
+		return time.Time{}, false
+	}
+	lead := c.config.TokenExpiryLead
+	if lead == 0 {
+		lead = defaultTokenExpiryLead
+	}
+	return expiresAt, time.Until(expiresAt) < lead
+}
+
+// defaultGetTokenTimeout is a deadline of token event context when
+// Config.GetTokenTimeout not set.
+const defaultGetTokenTimeout = 10 * time.Second
+
+// tokenContext returns context for token events which is cancelled when
+// Client closed.
+func (c *Client) tokenContext() (context.Context, context.CancelFunc) {
+	timeout := c.config.GetTokenTimeout
+	if timeout == 0 {
+		timeout = defaultGetTokenTimeout
+	}
+	return context.WithTimeout(c.ctx, timeout)
+}
+
+// refreshDelay returns a delay before refreshing token with ttl in seconds
+// according to Config.TokenRefreshRatio.
+func (c *Client) refreshDelay(ttl uint32) time.Duration {
+	delay := time.Duration(ttl) * time.Second
+	ratio := c.config.TokenRefreshRatio
+	if ratio <= 0 || ratio >= 1 {
+		return delay
+	}
+	jitter := int64(delay / 10)
+	delay = time.Duration(float64(delay) * ratio)
+	if jitter > 0 {
+		delay -= time.Duration(rand.Int63n(jitter + 1))
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
 func (c *Client) refreshToken() (string, error) {
 	handler := c.config.GetToken
 	if handler == nil {
 		return "", errors.New("GetToken must be set to handle expired token")
 	}
-	return handler(ConnectionTokenEvent{})
+	ctx, cancel := c.tokenContext()
+	defer cancel()
+	return handler(ConnectionTokenEvent{Context: ctx})
 }
 
 func (c *Client) sendRefresh() {
@@ -1247,7 +1648,7 @@ func (c *Client) sendRefresh() {
 		if expires {
 			c.mu.Lock()
 			if c.state == StateConnected {
-				c.refreshTimer = time.AfterFunc(time.Duration(ttl)*time.Second, c.sendRefresh)
+				c.refreshTimer = time.AfterFunc(c.refreshDelay(ttl), c.sendRefresh)
 			}
 			c.mu.Unlock()
 		}
@@ -1286,7 +1687,8 @@ func (c *Client) sendSubRefresh(channel string, token string, fn func(*protocol.
 	})
 }
 
-func (c *Client) sendConnect(fn func(*protocol.ConnectResult, error)) error {
+// connectCommand builds connect command. Lock must be held outside.
+func (c *Client) connectCommand() *protocol.Command {
 	cmd := &protocol.Command{
 		Id: c.nextCmdID(),
 	}
@@ -1313,10 +1715,18 @@ func (c *Client) sendConnect(fn func(*protocol.ConnectResult, error)) error {
 			}
 			params.Subs = subs
 		}
+		if c.config.PositionStore != nil {
+			c.addStoredServerSubs(params)
+		}
 		cmd.Connect = params
 	}
+	return cmd
+}
 
-	return c.sendAsync(cmd, func(reply *protocol.Reply, err error) {
+func (c *Client) sendConnect(cmd *protocol.Command, fn func(*protocol.ConnectResult, error)) error {
+	// Connect command is written synchronously to get connection errors of
+	// transports which establish connection on first write.
+	return c.sendAsyncWith(c.sendSync, cmd, func(reply *protocol.Reply, err error) {
 		if err != nil {
 			fn(nil, err)
 			return
@@ -1329,6 +1739,67 @@ func (c *Client) sendConnect(fn func(*protocol.ConnectResult, error)) error {
 	})
 }
 
+// addStoredServerSubs adds positions from PositionStore to connect request
+// to recover server-side subscriptions after process restart. Server ignores
+// channels which are not server-side subscriptions of connection.
+// Lock must be held outside.
+func (c *Client) addStoredServerSubs(params *protocol.ConnectRequest) {
+	channels, err := c.config.PositionStore.Channels()
+	if err != nil {
+		c.handleErrorAsync(fmt.Errorf("error loading stream positions: %w", err))
+		return
+	}
+	for _, channel := range channels {
+		if _, ok := c.serverSubs[channel]; ok {
+			continue
+		}
+		if _, ok := c.subs[channel]; ok {
+			continue
+		}
+		pos, ok, err := c.config.PositionStore.Load(channel)
+		if err != nil {
+			c.handleErrorAsync(fmt.Errorf("error loading stream position: %w", err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if params.Subs == nil {
+			params.Subs = make(map[string]*protocol.SubscribeRequest)
+		}
+		params.Subs[channel] = &protocol.SubscribeRequest{
+			Recover: true,
+			Epoch:   pos.Epoch,
+			Offset:  pos.Offset,
+		}
+	}
+}
+
+// positionFlusher is implemented by PositionStore which writes positions
+// in background, e.g. FilePositionStore.
+type positionFlusher interface {
+	Flush() error
+}
+
+// flushPositions writes pending positions of PositionStore on Client close.
+func (c *Client) flushPositions() {
+	if f, ok := c.config.PositionStore.(positionFlusher); ok {
+		if err := f.Flush(); err != nil {
+			c.handleErrorAsync(fmt.Errorf("error saving stream position: %w", err))
+		}
+	}
+}
+
+// savePosition saves stream position of channel to PositionStore.
+func (c *Client) savePosition(channel string, pos StreamPosition) {
+	if c.config.PositionStore == nil {
+		return
+	}
+	if err := c.config.PositionStore.Save(channel, pos); err != nil {
+		c.handleErrorAsync(fmt.Errorf("error saving stream position: %w", err))
+	}
+}
+
 type StreamPosition struct {
 	Offset uint64
 	Epoch  string
@@ -1771,9 +2242,13 @@ func (c *Client) sendUnsubscribe(channel string, fn func(UnsubscribeResult, erro
 }
 
 func (c *Client) sendAsync(cmd *protocol.Command, cb func(*protocol.Reply, error)) error {
+	return c.sendAsyncWith(c.send, cmd, cb)
+}
+
+func (c *Client) sendAsyncWith(send func(*protocol.Command) error, cmd *protocol.Command, cb func(*protocol.Reply, error)) error {
 	c.addRequest(cmd.Id, cb)
 
-	err := c.send(cmd)
+	err := send(cmd)
 	if err != nil {
 		return err
 	}
@@ -1804,15 +2279,43 @@ func (c *Client) sendAsync(cmd *protocol.Command, cb func(*protocol.Reply, error
 	return nil
 }
 
+// swapWriter sets writer of outbound queue and closes the previous one.
+func (c *Client) swapWriter(w *writer) {
+	c.writerMu.Lock()
+	prev := c.writer
+	c.writer = w
+	c.writerMu.Unlock()
+	if prev != nil {
+		prev.close()
+	}
+}
+
+// send puts command to outbound queue.
 func (c *Client) send(cmd *protocol.Command) error {
+	c.writerMu.Lock()
+	w := c.writer
+	c.writerMu.Unlock()
+	if w == nil {
+		return ErrClientDisconnected
+	}
+	err := w.enqueue(cmd)
+	if err == ErrOutboundQueueFull && c.config.OutboundQueueOverflow == OverflowDrop {
+		c.handleErrorAsync(err)
+		return nil
+	}
+	return err
+}
+
+// sendSync writes command to transport on caller goroutine.
+func (c *Client) sendSync(cmd *protocol.Command) error {
 	transport := c.transport
 	if transport == nil {
 		return ErrClientDisconnected
 	}
 	err := transport.Write(cmd, c.config.WriteTimeout)
 	if err != nil {
-		go c.handleDisconnect(&disconnect{Code: connectingTransportClosed, Reason: "write error", Reconnect: true})
-		return io.EOF
+		go c.handleDisconnect(&Disconnect{Code: connectingTransportClosed, Reason: "write error", Reconnect: true})
+		return TransportError{err}
 	}
 	return nil
 }
@@ -1833,12 +2336,6 @@ func (c *Client) removeRequest(id uint32) {
 	delete(c.requests, id)
 }
 
-type disconnect struct {
-	Code      uint32
-	Reason    string
-	Reconnect bool
-}
-
 type serverSub struct {
 	Offset      uint64
 	Epoch       string
@@ -1,16 +1,35 @@
 package centrifuge
 
-// ConnectionTokenEvent may contain some useful contextual information in the future.
-// For now, it's empty.
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ConnectionTokenEvent is passed to Config.GetToken.
 type ConnectionTokenEvent struct {
+	// Context has Config.GetTokenTimeout deadline and is cancelled when
+	// Client closed.
+	Context context.Context
 }
 
 // SubscriptionTokenEvent contains info required to get subscription token when
 // client wants to subscribe on private channel.
 type SubscriptionTokenEvent struct {
+	// Context has Config.GetTokenTimeout deadline and is cancelled when
+	// Client closed.
+	Context context.Context
 	Channel string
 }
 
+// SubscriptionTokensEvent contains channels which subscriptions need tokens
+// to resubscribe.
+type SubscriptionTokensEvent struct {
+	// Context has Config.GetTokenTimeout deadline and is cancelled when
+	// Client closed.
+	Context  context.Context
+	Channels []string
+}
+
 // ServerPublicationEvent has info about received channel Publication.
 type ServerPublicationEvent struct {
 	Channel string
@@ -68,6 +87,15 @@ type DisconnectedEvent struct {
 	Reason string
 }
 
+// TransportSwitchedEvent is an event passed to OnTransportSwitched callback
+// when Client falls back to another transport from Config.Transports.
+type TransportSwitchedEvent struct {
+	// Transport to be used for next connection attempts.
+	Transport TransportEndpoint
+	// PrevTransport is a transport which failed to connect.
+	PrevTransport TransportEndpoint
+}
+
 // ErrorEvent is an error event context passed to OnError callback.
 type ErrorEvent struct {
 	Error error
@@ -114,11 +142,28 @@ type ServerJoinHandler func(ServerJoinEvent)
 // server-side subscriptions.
 type ServerLeaveHandler func(ServerLeaveEvent)
 
+// TransportSwitchedHandler is an interface describing how to handle transport
+// switch event.
+type TransportSwitchedHandler func(TransportSwitchedEvent)
+
 // ErrorHandler is an interface describing how to handle error event.
 type ErrorHandler func(ErrorEvent)
 
-// eventHub has all event handlers for client.
+// eventHub has all event handlers for client. Dispatch functions are called
+// when corresponding event happens, they are built by rebuild from handlers
+// set by user and event channels.
 type eventHub struct {
+	// dispatch has *eventDispatch built by rebuild. It's replaced as a whole,
+	// so handlers can be added and removed while events dispatched.
+	dispatch atomic.Value
+
+	registry handlerRegistry
+	handlers eventHandlers
+	chans    []*eventChan
+}
+
+// eventDispatch has functions called when corresponding event happens.
+type eventDispatch struct {
 	onConnected          ConnectedHandler
 	onDisconnected       DisconnectHandler
 	onConnecting         ConnectingHandler
@@ -130,64 +175,305 @@ type eventHub struct {
 	onServerPublication  ServerPublicationHandler
 	onServerJoin         ServerJoinHandler
 	onServerLeave        ServerLeaveHandler
+	onTransportSwitched  TransportSwitchedHandler
+}
+
+// eventHandlers has event handlers set by user.
+type eventHandlers struct {
+	onConnected          handlerList
+	onDisconnected       handlerList
+	onConnecting         handlerList
+	onError              handlerList
+	onMessage            handlerList
+	onServerSubscribe    handlerList
+	onServerSubscribing  handlerList
+	onServerUnsubscribed handlerList
+	onServerPublication  handlerList
+	onServerJoin         handlerList
+	onServerLeave        handlerList
+	onTransportSwitched  handlerList
 }
 
 // newEventHub initializes new eventHub.
 func newEventHub() *eventHub {
-	return &eventHub{}
+	h := &eventHub{}
+	h.dispatch.Store(&eventDispatch{})
+	return h
+}
+
+// load returns current handlers to call on events.
+func (h *eventHub) load() *eventDispatch {
+	return h.dispatch.Load().(*eventDispatch)
+}
+
+// rebuild publishes new dispatch calling user handlers in registration order
+// and sending events to event channels. Registry lock must be held outside.
+func (h *eventHub) rebuild() {
+	d := &eventDispatch{}
+	chans := h.chans
+	if fns := h.handlers.onConnected.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onConnected = func(e ConnectedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ConnectedHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onDisconnected.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onDisconnected = func(e DisconnectedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(DisconnectHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onConnecting.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onConnecting = func(e ConnectingEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ConnectingHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onError.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onError = func(e ErrorEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ErrorHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onMessage.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onMessage = func(e MessageEvent) {
+			for _, fn := range fns {
+				if fn := fn.(MessageHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onServerSubscribe.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onServerSubscribe = func(e ServerSubscribedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ServerSubscribedHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onServerSubscribing.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onServerSubscribing = func(e ServerSubscribingEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ServerSubscribingHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onServerUnsubscribed.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onServerUnsubscribed = func(e ServerUnsubscribedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ServerUnsubscribedHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onServerPublication.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onServerPublication = func(e ServerPublicationEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ServerPublicationHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onServerJoin.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onServerJoin = func(e ServerJoinEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ServerJoinHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onServerLeave.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onServerLeave = func(e ServerLeaveEvent) {
+			for _, fn := range fns {
+				if fn := fn.(ServerLeaveHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	if fns := h.handlers.onTransportSwitched.fns(); len(fns) > 0 || len(chans) > 0 {
+		d.onTransportSwitched = func(e TransportSwitchedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(TransportSwitchedHandler); fn != nil {
+					fn(e)
+				}
+			}
+			sendEvent(chans, e)
+		}
+	}
+	h.dispatch.Store(d)
 }
 
 // OnConnected is a function to handle connect event.
 func (c *Client) OnConnected(handler ConnectedHandler) {
-	c.events.onConnected = handler
+	c.events.registry.set(&c.events.handlers.onConnected, handler, c.events.rebuild)
+}
+
+// AddConnectedHandler adds one more function to handle connect event.
+// Handlers are called in order of registration, handler set by OnConnected
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddConnectedHandler(handler ConnectedHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onConnected, handler, c.events.rebuild)
 }
 
 // OnConnecting is a function to handle connecting event.
 func (c *Client) OnConnecting(handler ConnectingHandler) {
-	c.events.onConnecting = handler
+	c.events.registry.set(&c.events.handlers.onConnecting, handler, c.events.rebuild)
+}
+
+// AddConnectingHandler adds one more function to handle connecting event.
+// Handlers are called in order of registration, handler set by OnConnecting
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddConnectingHandler(handler ConnectingHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onConnecting, handler, c.events.rebuild)
 }
 
 // OnDisconnected is a function to handle moveToDisconnected event.
 func (c *Client) OnDisconnected(handler DisconnectHandler) {
-	c.events.onDisconnected = handler
+	c.events.registry.set(&c.events.handlers.onDisconnected, handler, c.events.rebuild)
+}
+
+// AddDisconnectedHandler adds one more function to handle disconnect event.
+// Handlers are called in order of registration, handler set by OnDisconnected
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddDisconnectedHandler(handler DisconnectHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onDisconnected, handler, c.events.rebuild)
 }
 
 // OnError is a function that will receive unhandled errors for logging.
 func (c *Client) OnError(handler ErrorHandler) {
-	c.events.onError = handler
+	c.events.registry.set(&c.events.handlers.onError, handler, c.events.rebuild)
+}
+
+// AddErrorHandler adds one more function to handle unhandled errors.
+// Handlers are called in order of registration, handler set by OnError
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddErrorHandler(handler ErrorHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onError, handler, c.events.rebuild)
 }
 
 // OnMessage allows processing async message from server to client.
 func (c *Client) OnMessage(handler MessageHandler) {
-	c.events.onMessage = handler
+	c.events.registry.set(&c.events.handlers.onMessage, handler, c.events.rebuild)
+}
+
+// AddMessageHandler adds one more function to handle async messages from server.
+// Handlers are called in order of registration, handler set by OnMessage
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddMessageHandler(handler MessageHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onMessage, handler, c.events.rebuild)
 }
 
 // OnPublication sets function to handle Publications from server-side subscriptions.
 func (c *Client) OnPublication(handler ServerPublicationHandler) {
-	c.events.onServerPublication = handler
+	c.events.registry.set(&c.events.handlers.onServerPublication, handler, c.events.rebuild)
+}
+
+// AddPublicationHandler adds one more function to handle Publications from server-side subscriptions.
+// Handlers are called in order of registration, handler set by OnPublication
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddPublicationHandler(handler ServerPublicationHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onServerPublication, handler, c.events.rebuild)
 }
 
 // OnSubscribed sets function to handle server-side subscription subscribe events.
 func (c *Client) OnSubscribed(handler ServerSubscribedHandler) {
-	c.events.onServerSubscribe = handler
+	c.events.registry.set(&c.events.handlers.onServerSubscribe, handler, c.events.rebuild)
+}
+
+// AddSubscribedHandler adds one more function to handle server-side subscription subscribe events.
+// Handlers are called in order of registration, handler set by OnSubscribed
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddSubscribedHandler(handler ServerSubscribedHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onServerSubscribe, handler, c.events.rebuild)
 }
 
 // OnSubscribing sets function to handle server-side subscription subscribing events.
 func (c *Client) OnSubscribing(handler ServerSubscribingHandler) {
-	c.events.onServerSubscribing = handler
+	c.events.registry.set(&c.events.handlers.onServerSubscribing, handler, c.events.rebuild)
+}
+
+// AddSubscribingHandler adds one more function to handle server-side subscription subscribing events.
+// Handlers are called in order of registration, handler set by OnSubscribing
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddSubscribingHandler(handler ServerSubscribingHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onServerSubscribing, handler, c.events.rebuild)
 }
 
 // OnUnsubscribed sets function to handle unsubscribe from server-side subscriptions.
 func (c *Client) OnUnsubscribed(handler ServerUnsubscribedHandler) {
-	c.events.onServerUnsubscribed = handler
+	c.events.registry.set(&c.events.handlers.onServerUnsubscribed, handler, c.events.rebuild)
+}
+
+// AddUnsubscribedHandler adds one more function to handle unsubscribe from server-side subscriptions.
+// Handlers are called in order of registration, handler set by OnUnsubscribed
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddUnsubscribedHandler(handler ServerUnsubscribedHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onServerUnsubscribed, handler, c.events.rebuild)
 }
 
 // OnJoin sets function to handle Join event from server-side subscriptions.
 func (c *Client) OnJoin(handler ServerJoinHandler) {
-	c.events.onServerJoin = handler
+	c.events.registry.set(&c.events.handlers.onServerJoin, handler, c.events.rebuild)
+}
+
+// AddJoinHandler adds one more function to handle Join events from server-side subscriptions.
+// Handlers are called in order of registration, handler set by OnJoin
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddJoinHandler(handler ServerJoinHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onServerJoin, handler, c.events.rebuild)
 }
 
 // OnLeave sets function to handle Leave event from server-side subscriptions.
 func (c *Client) OnLeave(handler ServerLeaveHandler) {
-	c.events.onServerLeave = handler
+	c.events.registry.set(&c.events.handlers.onServerLeave, handler, c.events.rebuild)
+}
+
+// AddLeaveHandler adds one more function to handle Leave events from server-side subscriptions.
+// Handlers are called in order of registration, handler set by OnLeave
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddLeaveHandler(handler ServerLeaveHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onServerLeave, handler, c.events.rebuild)
+}
+
+// OnTransportSwitched sets function to handle transport switch event.
+func (c *Client) OnTransportSwitched(handler TransportSwitchedHandler) {
+	c.events.registry.set(&c.events.handlers.onTransportSwitched, handler, c.events.rebuild)
+}
+
+// AddTransportSwitchedHandler adds one more function to handle transport switch event.
+// Handlers are called in order of registration, handler set by OnTransportSwitched
+// keeps its position. Call returned function to remove handler.
+func (c *Client) AddTransportSwitchedHandler(handler TransportSwitchedHandler) (remove func()) {
+	return c.events.registry.add(&c.events.handlers.onTransportSwitched, handler, c.events.rebuild)
 }
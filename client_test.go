@@ -3,12 +3,24 @@ package centrifuge
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/centrifugal/centrifuge-go/jwt"
+	"github.com/centrifugal/protocol"
+	"github.com/gorilla/websocket"
 )
 
 type testEventHandler struct {
@@ -392,3 +404,2104 @@ func TestClient_History(t *testing.T) {
 		t.Fatal("expected not available error, got " + strconv.FormatUint(uint64(e.Code), 10))
 	}
 }
+
+// testTransport is an in-memory Transport which replies to commands using
+// handler function.
+type testTransport struct {
+	mu      sync.Mutex
+	handler func(cmd *protocol.Command) []*protocol.Reply
+	replyCh chan *protocol.Reply
+	closeCh chan struct{}
+	closed  bool
+}
+
+func newTestTransport(handler func(cmd *protocol.Command) []*protocol.Reply) *testTransport {
+	return &testTransport{
+		handler: handler,
+		replyCh: make(chan *protocol.Reply, 128),
+		closeCh: make(chan struct{}),
+	}
+}
+
+func (t *testTransport) Read() (*protocol.Reply, *Disconnect, error) {
+	select {
+	case reply := <-t.replyCh:
+		return reply, nil, nil
+	case <-t.closeCh:
+		return nil, nil, io.EOF
+	}
+}
+
+func (t *testTransport) Write(cmd *protocol.Command, _ time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return io.EOF
+	}
+	for _, reply := range t.handler(cmd) {
+		t.replyCh <- reply
+	}
+	return nil
+}
+
+func (t *testTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.closeCh)
+	}
+	return nil
+}
+
+func testConnectHandler(cmd *protocol.Command) []*protocol.Reply {
+	if cmd.Connect != nil {
+		return []*protocol.Reply{{Id: cmd.Id, Connect: &protocol.ConnectResult{Client: "test"}}}
+	}
+	return nil
+}
+
+func TestCustomTransportFactory(t *testing.T) {
+	var endpoint string
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(e string, _ protocol.Type, _ Config) (Transport, error) {
+			endpoint = e
+			return newTestTransport(testConnectHandler), nil
+		},
+	})
+	defer client.Close()
+	doneCh := make(chan ConnectedEvent, 1)
+	client.OnConnected(func(e ConnectedEvent) {
+		doneCh <- e
+	})
+	_ = client.Connect()
+	select {
+	case e := <-doneCh:
+		if e.ClientID != "test" {
+			t.Errorf("unexpected client ID: %s", e.ClientID)
+		}
+		if endpoint != "memory://test" {
+			t.Errorf("unexpected endpoint: %s", endpoint)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expecting successful connect")
+	}
+}
+
+// testEmulationServer emulates Centrifugo bidirectional emulation endpoints
+// for JSON protocol. It replies to connect and RPC commands.
+type testEmulationServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	sessions map[string]chan *protocol.Reply
+}
+
+func newTestEmulationServer() *testEmulationServer {
+	s := &testEmulationServer{sessions: make(map[string]chan *protocol.Reply)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connection/http_stream", s.handleHTTPStream)
+	mux.HandleFunc("/connection/sse", s.handleSSE)
+	mux.HandleFunc("/emulation", s.handleEmulation)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *testEmulationServer) newSession() (string, chan *protocol.Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := strconv.Itoa(len(s.sessions) + 1)
+	ch := make(chan *protocol.Reply, 16)
+	s.sessions[session] = ch
+	return session, ch
+}
+
+func (s *testEmulationServer) handleEmulation(w http.ResponseWriter, r *http.Request) {
+	var req protocol.EmulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	ch, ok := s.sessions[req.Session]
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	cmd, err := protocol.NewJSONCommandDecoder(req.Data).Decode()
+	if err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if cmd.Rpc != nil {
+		ch <- &protocol.Reply{Id: cmd.Id, Rpc: &protocol.RPCResult{Data: cmd.Rpc.Data}}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stream sends replies from session to a client until request done.
+func (s *testEmulationServer) stream(w http.ResponseWriter, r *http.Request, data []byte, format func([]byte) []byte) {
+	cmd, err := protocol.NewJSONCommandDecoder(data).Decode()
+	if (err != nil && err != io.EOF) || cmd.Connect == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	session, ch := s.newSession()
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	ch <- &protocol.Reply{Id: cmd.Id, Connect: &protocol.ConnectResult{Client: "test", Session: session, Node: "node"}}
+	encoder := protocol.NewJSONReplyEncoder()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case reply := <-ch:
+			data, _ := encoder.Encode(reply)
+			_, _ = w.Write(format(data))
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func (s *testEmulationServer) handleHTTPStream(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	s.stream(w, r, data, func(data []byte) []byte {
+		return append(data, '\n')
+	})
+}
+
+func (s *testEmulationServer) handleSSE(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	s.stream(w, r, []byte(r.URL.Query().Get("cf_connect")), func(data []byte) []byte {
+		return []byte(": comment\nevent: message\ndata: " + string(data) + "\n\n")
+	})
+}
+
+func TestHTTPStreamTransport(t *testing.T) {
+	server := newTestEmulationServer()
+	defer server.Close()
+
+	client := NewJsonClient(server.URL+"/connection/http_stream", Config{})
+	defer client.Close()
+	client.OnError(func(e ErrorEvent) {
+		t.Log(e.Error)
+	})
+	_ = client.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.RPC(ctx, "echo", []byte(`{"test":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Data) != `{"test":1}` {
+		t.Errorf("unexpected RPC result: %s", result.Data)
+	}
+}
+
+func TestHTTPStreamTransportOpensStreamOnCreate(t *testing.T) {
+	server := newTestEmulationServer()
+	defer server.Close()
+
+	opts := transportOptions{connectCommand: &protocol.Command{Id: 1, Connect: &protocol.ConnectRequest{}}}
+	transport, err := newHTTPStreamTransport(server.URL+"/connection/http_stream", protocol.TypeJSON, Config{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = transport.Close() }()
+	// Connect reply is received without writing connect command.
+	reply, _, err := transport.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Id != 1 || reply.Connect == nil {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+	if err := transport.Write(opts.connectCommand, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSSETransport(t *testing.T) {
+	server := newTestEmulationServer()
+	defer server.Close()
+
+	client := NewJsonClient(server.URL+"/connection/sse", Config{
+		TransportFactory: NewSSETransport,
+	})
+	defer client.Close()
+	client.OnError(func(e ErrorEvent) {
+		t.Log(e.Error)
+	})
+	_ = client.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.RPC(ctx, "echo", []byte(`{"test":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Data) != `{"test":1}` {
+		t.Errorf("unexpected RPC result: %s", result.Data)
+	}
+}
+
+func TestTransportFallback(t *testing.T) {
+	server := newTestEmulationServer()
+	defer server.Close()
+
+	client := NewJsonClient("", Config{
+		Transports: []TransportEndpoint{
+			{Transport: TransportWebsocket, Endpoint: "ws://localhost:9000/connection/websocket"},
+			{Transport: TransportHTTPStream, Endpoint: server.URL + "/connection/http_stream"},
+		},
+		TransportMaxFailures: 2,
+	})
+	defer client.Close()
+	switchedCh := make(chan TransportSwitchedEvent, 1)
+	client.OnTransportSwitched(func(e TransportSwitchedEvent) {
+		switchedCh <- e
+	})
+	connectedCh := make(chan struct{})
+	client.OnConnected(func(e ConnectedEvent) {
+		close(connectedCh)
+	})
+	_ = client.Connect()
+	select {
+	case e := <-switchedCh:
+		if e.PrevTransport.Transport != TransportWebsocket || e.Transport.Transport != TransportHTTPStream {
+			t.Errorf("unexpected transport switch: %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting transport switch")
+	}
+	select {
+	case <-connectedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting successful connect")
+	}
+}
+
+func TestCappedReconnect(t *testing.T) {
+	var numAttempts int32
+	client := NewJsonClient("ws://localhost:9000/connection/websocket", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			atomic.AddInt32(&numAttempts, 1)
+			return nil, errors.New("boom")
+		},
+		ReconnectStrategy: &CappedReconnect{
+			Strategy:    &ConstantReconnect{Delay: 10 * time.Millisecond},
+			MaxAttempts: 2,
+		},
+	})
+	defer client.Close()
+	disconnectedCh := make(chan DisconnectedEvent, 1)
+	client.OnDisconnected(func(e DisconnectedEvent) {
+		disconnectedCh <- e
+	})
+	_ = client.Connect()
+	select {
+	case e := <-disconnectedCh:
+		if e.Code != disconnectedReconnectStopped {
+			t.Errorf("unexpected disconnect code: %d", e.Code)
+		}
+		if n := atomic.LoadInt32(&numAttempts); n != 3 {
+			t.Errorf("expected 3 connection attempts, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting disconnect")
+	}
+}
+
+func TestJitterReconnectZeroDelays(t *testing.T) {
+	strategies := []ReconnectStrategy{&FullJitterReconnect{}, &DecorrelatedJitterReconnect{}}
+	for _, strategy := range strategies {
+		for attempt := 1; attempt <= 3; attempt++ {
+			var nonZero bool
+			for i := 0; i < 100; i++ {
+				delay, ok := strategy.TimeBeforeNextAttempt(attempt)
+				if !ok {
+					t.Fatalf("%T: unexpected stop", strategy)
+				}
+				if delay > defaultBackoffReconnect.MaxDelay {
+					t.Fatalf("%T: delay %s above default cap", strategy, delay)
+				}
+				if delay > 0 {
+					nonZero = true
+				}
+			}
+			if !nonZero {
+				t.Fatalf("%T: expected non-zero delays for attempt %d", strategy, attempt)
+			}
+		}
+	}
+	if delay, _ := (&DecorrelatedJitterReconnect{}).TimeBeforeNextAttempt(1); delay < defaultBackoffReconnect.MinDelay {
+		t.Fatalf("expected delay not below default minimum, got %s", delay)
+	}
+}
+
+func TestMaxReconnectAttempts(t *testing.T) {
+	var numAttempts int32
+	client := NewJsonClient("ws://localhost:9000/connection/websocket", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			atomic.AddInt32(&numAttempts, 1)
+			return nil, errors.New("boom")
+		},
+		ReconnectStrategy:    &ConstantReconnect{Delay: 10 * time.Millisecond},
+		MaxReconnectAttempts: 2,
+	})
+	defer client.Close()
+	disconnectedCh := make(chan DisconnectedEvent, 1)
+	client.OnDisconnected(func(e DisconnectedEvent) {
+		disconnectedCh <- e
+	})
+	_ = client.Connect()
+	select {
+	case e := <-disconnectedCh:
+		if e.Code != disconnectedMaxAttempts || e.Reason != "max reconnect attempts reached" {
+			t.Errorf("unexpected disconnect: %d (%s)", e.Code, e.Reason)
+		}
+		if n := atomic.LoadInt32(&numAttempts); n != 3 {
+			t.Errorf("expected 3 connection attempts, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting disconnect")
+	}
+}
+
+func TestMaxReconnectDuration(t *testing.T) {
+	client := NewJsonClient("ws://localhost:9000/connection/websocket", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return nil, errors.New("boom")
+		},
+		ReconnectStrategy:    &ConstantReconnect{Delay: 50 * time.Millisecond},
+		MaxReconnectDuration: 200 * time.Millisecond,
+	})
+	defer client.Close()
+	disconnectedCh := make(chan DisconnectedEvent, 1)
+	client.OnDisconnected(func(e DisconnectedEvent) {
+		disconnectedCh <- e
+	})
+	_ = client.Connect()
+	select {
+	case e := <-disconnectedCh:
+		if e.Code != disconnectedMaxDuration || e.Reason != "max reconnect duration reached" {
+			t.Errorf("unexpected disconnect: %d (%s)", e.Code, e.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting disconnect")
+	}
+	if client.State() != StateDisconnected {
+		t.Errorf("unexpected state: %s", client.State())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	attemptCh := make(chan time.Time, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attemptCh <- time.Now()
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewJsonClient("ws"+strings.TrimPrefix(server.URL, "http"), Config{
+		ReconnectStrategy: &ConstantReconnect{Delay: 10 * time.Millisecond},
+	})
+	defer client.Close()
+	errCh := make(chan error, 1)
+	client.OnError(func(e ErrorEvent) {
+		select {
+		case errCh <- e.Error:
+		default:
+		}
+	})
+	_ = client.Connect()
+	var retryAfterErr *RetryAfterError
+	if err := <-errCh; !errors.As(err, &retryAfterErr) || retryAfterErr.RetryAfter != time.Second {
+		t.Fatalf("expected RetryAfterError, got %v", err)
+	}
+	first := <-attemptCh
+	select {
+	case second := <-attemptCh:
+		if second.Sub(first) < time.Second {
+			t.Errorf("reconnected too early: %s", second.Sub(first))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting reconnect")
+	}
+}
+
+func TestWebsocketWriteBatching(t *testing.T) {
+	frames := make(chan []byte, 10)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			frames <- data
+		}
+	}))
+	defer server.Close()
+
+	transport, err := newWebsocketTransport("ws"+strings.TrimPrefix(server.URL, "http"), protocol.TypeJSON, websocketConfig{
+		WriteBatchInterval: 50 * time.Millisecond,
+		WriteBatchMaxSize:  3,
+	})
+	if err != nil {
+		t.Fatalf("error dial: %v", err)
+	}
+	defer func() { _ = transport.Close() }()
+
+	for i := 1; i <= 5; i++ {
+		if err := transport.Write(&protocol.Command{Id: uint32(i), Method: protocol.Command_PING}, time.Second); err != nil {
+			t.Fatalf("error write: %v", err)
+		}
+	}
+	expected := []int{3, 2}
+	for _, n := range expected {
+		select {
+		case data := <-frames:
+			if lines := strings.Split(string(data), "\n"); len(lines) != n {
+				t.Fatalf("expected %d commands in frame, got %d: %s", n, len(lines), data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for frame")
+		}
+	}
+}
+
+func TestResubscribeBatched(t *testing.T) {
+	var inflight, maxInflight, tokenCalls, maxTokenCalls int32
+	updateMax := func(max *int32, v int32) {
+		for {
+			m := atomic.LoadInt32(max)
+			if v <= m || atomic.CompareAndSwapInt32(max, m, v) {
+				return
+			}
+		}
+	}
+	var transport *testTransport
+	transport = newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+		if cmd.Subscribe != nil {
+			updateMax(&maxInflight, atomic.AddInt32(&inflight, 1))
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&inflight, -1)
+				transport.replyCh <- &protocol.Reply{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}}
+			}()
+			return nil
+		}
+		return testConnectHandler(cmd)
+	})
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return transport, nil
+		},
+		ResubscribeBatchSize:        3,
+		ResubscribeTokenConcurrency: 2,
+	})
+	defer client.Close()
+
+	const numSubs = 10
+	subscribed := make(chan struct{}, numSubs)
+	for i := 0; i < numSubs; i++ {
+		sub, err := client.NewSubscription("channel"+strconv.Itoa(i), SubscriptionConfig{
+			GetToken: func(SubscriptionTokenEvent) (string, error) {
+				updateMax(&maxTokenCalls, atomic.AddInt32(&tokenCalls, 1))
+				defer atomic.AddInt32(&tokenCalls, -1)
+				time.Sleep(5 * time.Millisecond)
+				return "token", nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("error on new subscription: %v", err)
+		}
+		sub.OnSubscribed(func(SubscribedEvent) {
+			subscribed <- struct{}{}
+		})
+		if err := sub.Subscribe(); err != nil {
+			t.Fatalf("error on subscribe: %v", err)
+		}
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for i := 0; i < numSubs; i++ {
+		select {
+		case <-subscribed:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for subscribe, %d subscribed", i)
+		}
+	}
+	if m := atomic.LoadInt32(&maxInflight); m > 3 {
+		t.Fatalf("expected at most 3 subscribe commands in flight, got %d", m)
+	}
+	if m := atomic.LoadInt32(&maxTokenCalls); m > 2 {
+		t.Fatalf("expected at most 2 parallel token calls, got %d", m)
+	}
+}
+
+func TestOutboundQueueOverflowError(t *testing.T) {
+	writing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Send != nil {
+					writing <- struct{}{}
+					<-release
+					return nil
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+		OutboundQueueSize:     1,
+		OutboundQueueOverflow: OverflowError,
+	})
+	defer client.Close()
+	defer close(release)
+
+	connected := make(chan struct{})
+	client.OnConnected(func(ConnectedEvent) {
+		close(connected)
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	<-connected
+
+	ctx := context.Background()
+	// First command blocks writer, second one waits in queue.
+	if err := client.Send(ctx, []byte(`{}`)); err != nil {
+		t.Fatalf("error on send: %v", err)
+	}
+	<-writing
+	if err := client.Send(ctx, []byte(`{}`)); err != nil {
+		t.Fatalf("error on send: %v", err)
+	}
+	if err := client.Send(ctx, []byte(`{}`)); !errors.Is(err, ErrOutboundQueueFull) {
+		t.Fatalf("expected ErrOutboundQueueFull, got %v", err)
+	}
+}
+
+type failingTransport struct {
+	release chan struct{}
+}
+
+func (t *failingTransport) Read() (*protocol.Reply, *Disconnect, error) {
+	return nil, nil, io.EOF
+}
+
+func (t *failingTransport) Write(*protocol.Command, time.Duration) error {
+	<-t.release
+	return errors.New("write failed")
+}
+
+func (t *failingTransport) Close() error {
+	return nil
+}
+
+func TestWriterErrorReleasesBlockedEnqueue(t *testing.T) {
+	transport := &failingTransport{release: make(chan struct{})}
+	w := newWriter(transport, 1, OverflowBlock, 0, func(error) {})
+	// First command blocks in Write, second one fills queue.
+	if err := w.enqueue(&protocol.Command{Id: 1}); err != nil {
+		t.Fatalf("error on enqueue: %v", err)
+	}
+	if err := w.enqueue(&protocol.Command{Id: 2}); err != nil {
+		t.Fatalf("error on enqueue: %v", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.enqueue(&protocol.Command{Id: 3})
+	}()
+	close(transport.release)
+	select {
+	case err := <-errCh:
+		if err != ErrClientDisconnected {
+			t.Fatalf("expected ErrClientDisconnected, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("enqueue blocked after write error")
+	}
+}
+
+func TestTagsFilter(t *testing.T) {
+	filter := TagsOr(
+		TagsAnd(TagsEq("type", "alert"), TagsIn("level", "high", "critical")),
+		TagsEq("type", "digest"),
+	)
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					pub := func(data string, tags map[string]string) *protocol.Reply {
+						return &protocol.Reply{Push: &protocol.Push{
+							Channel: cmd.Subscribe.Channel,
+							Pub:     &protocol.Publication{Data: []byte(data), Tags: tags},
+						}}
+					}
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}},
+						pub(`"1"`, map[string]string{"type": "alert", "level": "low"}),
+						pub(`"2"`, map[string]string{"type": "alert", "level": "critical"}),
+						pub(`"3"`, nil),
+						pub(`"4"`, map[string]string{"type": "digest"}),
+					}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{TagsFilter: filter})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubs := make(chan string, 4)
+	sub.OnPublication(func(e PublicationEvent) {
+		pubs <- string(e.Data)
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for _, expected := range []string{`"2"`, `"4"`} {
+		select {
+		case data := <-pubs:
+			if data != expected {
+				t.Fatalf("expected publication %s, got %s", expected, data)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for publication")
+		}
+	}
+}
+
+func TestTagsFilterRecoveredPublications(t *testing.T) {
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					return []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{
+						Recoverable:   true,
+						WasRecovering: true,
+						Recovered:     true,
+						Epoch:         "epoch",
+						Offset:        7,
+						Publications: []*protocol.Publication{
+							{Data: []byte(`"6"`), Offset: 6, Tags: map[string]string{"type": "digest"}},
+							{Data: []byte(`"7"`), Offset: 7, Tags: map[string]string{"type": "alert"}},
+						},
+					}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{
+		Since:      &StreamPosition{Offset: 5, Epoch: "epoch"},
+		TagsFilter: TagsEq("type", "alert"),
+	})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubs := make(chan string, 2)
+	sub.OnPublication(func(e PublicationEvent) {
+		pubs <- string(e.Data)
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case data := <-pubs:
+		if data != `"7"` {
+			t.Fatalf("expected only matching recovered publication, got %s", data)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for publication")
+	}
+}
+
+func TestTagsFilterNilNodes(t *testing.T) {
+	tags := map[string]string{"type": "alert"}
+	var filter *TagsFilter
+	if filter.Match(tags) {
+		t.Fatal("nil filter must not match")
+	}
+	if TagsAnd(TagsEq("type", "alert"), nil).Match(tags) {
+		t.Fatal("and node with nil child must not match")
+	}
+	if !TagsOr(nil, TagsEq("type", "alert")).Match(tags) {
+		t.Fatal("or node must match by non-nil child")
+	}
+}
+
+func TestFilePositionStore(t *testing.T) {
+	path := t.TempDir() + "/positions.json"
+	subscribeCh := make(chan *protocol.SubscribeRequest, 2)
+	newClient := func() *Client {
+		store, err := NewFilePositionStore(path)
+		if err != nil {
+			t.Fatalf("error creating store: %v", err)
+		}
+		return NewJsonClient("memory://test", Config{
+			TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+				return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+					if cmd.Subscribe != nil {
+						subscribeCh <- cmd.Subscribe
+						return []*protocol.Reply{
+							{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, Epoch: "epoch", Offset: 5}},
+							{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte(`{}`), Offset: 6}}},
+						}
+					}
+					return testConnectHandler(cmd)
+				}), nil
+			},
+			PositionStore: store,
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		client := newClient()
+		sub, err := client.NewSubscription("test")
+		if err != nil {
+			t.Fatalf("error on new subscription: %v", err)
+		}
+		pubCh := make(chan struct{}, 1)
+		sub.OnPublication(func(PublicationEvent) {
+			pubCh <- struct{}{}
+		})
+		_ = sub.Subscribe()
+		if err := client.Connect(); err != nil {
+			t.Fatalf("error on connect: %v", err)
+		}
+		req := <-subscribeCh
+		if i == 0 && req.Recover {
+			t.Fatal("unexpected recover on first subscribe")
+		}
+		if i == 1 && (!req.Recover || req.Offset != 6 || req.Epoch != "epoch") {
+			t.Fatalf("expected recover from stored position, got %v", req)
+		}
+		select {
+		case <-pubCh:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for publication")
+		}
+		client.Close()
+	}
+}
+
+func TestPositionSavedAfterHandler(t *testing.T) {
+	store := NewMemoryPositionStore()
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, Epoch: "epoch", Offset: 5}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte(`{}`), Offset: 6}}},
+					}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+		PositionStore: store,
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{Recoverable: true, Positioned: true})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	done := make(chan struct{})
+	sub.OnPublication(func(e PublicationEvent) {
+		if pos, _, _ := store.Load("test"); pos.Offset >= e.Offset {
+			t.Errorf("position %d saved before publication %d processed", pos.Offset, e.Offset)
+		}
+		close(done)
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for publication")
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if pos, _, _ := store.Load("test"); pos.Offset == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("position not saved after publication processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFilePositionStoreFlush(t *testing.T) {
+	path := t.TempDir() + "/positions.json"
+	store, err := NewFilePositionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(1); i <= 100; i++ {
+		if err := store.Save("test", StreamPosition{Offset: i, Epoch: "epoch"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no file written on Save, got %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewFilePositionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos, ok, _ := loaded.Load("test")
+	if !ok || pos.Offset != 100 || pos.Epoch != "epoch" {
+		t.Fatalf("unexpected position: %v %v", pos, ok)
+	}
+}
+
+func TestSubscribeSince(t *testing.T) {
+	subscribeCh := make(chan *protocol.SubscribeRequest, 1)
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					subscribeCh <- cmd.Subscribe
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, Recovered: true, Epoch: "epoch", Offset: 10}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte(`{}`), Offset: 11}}},
+					}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{
+		Since: &StreamPosition{Offset: 7, Epoch: "epoch"},
+	})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubCh := make(chan struct{}, 1)
+	sub.OnPublication(func(PublicationEvent) {
+		pubCh <- struct{}{}
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	req := <-subscribeCh
+	if !req.Recover || req.Offset != 7 || req.Epoch != "epoch" {
+		t.Fatalf("expected recover since position, got %v", req)
+	}
+	select {
+	case <-pubCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for publication")
+	}
+	pos := sub.StreamPosition()
+	if pos == nil || pos.Offset != 11 || pos.Epoch != "epoch" {
+		t.Fatalf("unexpected stream position: %v", pos)
+	}
+}
+
+func TestHistoryCatchUp(t *testing.T) {
+	pub := func(offset uint64) *protocol.Publication {
+		return &protocol.Publication{Data: []byte(strconv.FormatUint(offset, 10)), Offset: offset}
+	}
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				switch {
+				case cmd.Subscribe != nil:
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, WasRecovering: true, Epoch: "epoch", Offset: 5}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: pub(6)}},
+					}
+				case cmd.History != nil:
+					// Return two publications per page at most.
+					var pubs []*protocol.Publication
+					for o := cmd.History.Since.Offset + 1; o <= 5 && len(pubs) < 2; o++ {
+						pubs = append(pubs, pub(o))
+					}
+					return []*protocol.Reply{{Id: cmd.Id, History: &protocol.HistoryResult{Publications: pubs, Epoch: "epoch", Offset: 5}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{
+		Since:          &StreamPosition{Offset: 2, Epoch: "epoch"},
+		HistoryCatchUp: true,
+	})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubCh := make(chan string, 10)
+	sub.OnPublication(func(e PublicationEvent) {
+		pubCh <- string(e.Data)
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for _, expected := range []string{"3", "4", "5", "6"} {
+		select {
+		case data := <-pubCh:
+			if data != expected {
+				t.Fatalf("expected publication %s, got %s", expected, data)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for publication")
+		}
+	}
+}
+
+func TestHistoryCatchUpError(t *testing.T) {
+	var numSubscribes int32
+	resubscribeCh := make(chan *protocol.SubscribeRequest, 1)
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				switch {
+				case cmd.Subscribe != nil:
+					if atomic.AddInt32(&numSubscribes, 1) == 2 {
+						resubscribeCh <- cmd.Subscribe
+						return []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, Recovered: true, Epoch: "epoch", Offset: 5}}}
+					}
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, WasRecovering: true, Epoch: "epoch", Offset: 5}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte("6"), Offset: 6}}},
+					}
+				case cmd.History != nil:
+					return []*protocol.Reply{{Id: cmd.Id, Error: &protocol.Error{Code: 100, Message: "internal server error"}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{
+		Since:          &StreamPosition{Offset: 2, Epoch: "epoch"},
+		HistoryCatchUp: true,
+	})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	errCh := make(chan error, 1)
+	sub.OnError(func(e SubscriptionErrorEvent) {
+		select {
+		case errCh <- e.Error:
+		default:
+		}
+	})
+	sub.OnPublication(func(e PublicationEvent) {
+		t.Errorf("unexpected publication %s", e.Data)
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		var catchUpErr SubscriptionCatchUpError
+		if !errors.As(err, &catchUpErr) {
+			t.Fatalf("expected SubscriptionCatchUpError, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for catch-up error")
+	}
+	select {
+	case req := <-resubscribeCh:
+		if !req.Recover || req.Offset != 2 {
+			t.Fatalf("expected recovery from offset 2, got %v", req)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for resubscribe")
+	}
+}
+
+func TestHistoryCatchUpNoProgress(t *testing.T) {
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				switch {
+				case cmd.Subscribe != nil:
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, WasRecovering: true, Epoch: "epoch", Offset: 5}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte("6"), Offset: 6}}},
+					}
+				case cmd.History != nil:
+					// Publications without offsets do not move position.
+					return []*protocol.Reply{{Id: cmd.Id, History: &protocol.HistoryResult{
+						Publications: []*protocol.Publication{{Data: []byte("0")}},
+						Epoch:        "epoch",
+						Offset:       5,
+					}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{
+		Since:          &StreamPosition{Offset: 2, Epoch: "epoch"},
+		HistoryCatchUp: true,
+	})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubCh := make(chan string, 10)
+	sub.OnPublication(func(e PublicationEvent) {
+		pubCh <- string(e.Data)
+	})
+	gapCh := make(chan GapEvent, 1)
+	sub.OnGap(func(e GapEvent) {
+		gapCh <- e
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case e := <-gapCh:
+		if e.FromOffset != 3 || e.ToOffset != 5 {
+			t.Fatalf("unexpected gap: %v", e)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for gap event")
+	}
+	select {
+	case data := <-pubCh:
+		if data != "6" {
+			t.Fatalf("expected buffered publication 6, got %s", data)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for publication")
+	}
+}
+
+func TestPublicationGap(t *testing.T) {
+	pushPub := func(channel string, offset uint64) *protocol.Reply {
+		return &protocol.Reply{Push: &protocol.Push{Channel: channel, Pub: &protocol.Publication{
+			Data:   []byte(strconv.FormatUint(offset, 10)),
+			Offset: offset,
+		}}}
+	}
+	var numSubscribes int32
+	resubscribeCh := make(chan *protocol.SubscribeRequest, 1)
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				switch {
+				case cmd.Subscribe != nil && cmd.Subscribe.Channel == "events":
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, Epoch: "epoch", Offset: 5}},
+						pushPub("events", 6),
+						pushPub("events", 6),
+						pushPub("events", 9),
+					}
+				case cmd.Subscribe != nil && cmd.Subscribe.Channel == "resubscribe":
+					if atomic.AddInt32(&numSubscribes, 1) == 2 {
+						resubscribeCh <- cmd.Subscribe
+						return []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, Recovered: true, Epoch: "epoch", Offset: 8}}}
+					}
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Recoverable: true, Epoch: "epoch", Offset: 5}},
+						pushPub("resubscribe", 6),
+						pushPub("resubscribe", 8),
+					}
+				case cmd.Unsubscribe != nil:
+					return []*protocol.Reply{{Id: cmd.Id, Unsubscribe: &protocol.UnsubscribeResult{}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("events")
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubCh := make(chan string, 10)
+	sub.OnPublication(func(e PublicationEvent) {
+		pubCh <- string(e.Data)
+	})
+	gapCh := make(chan GapEvent, 1)
+	sub.OnGap(func(e GapEvent) {
+		gapCh <- e
+	})
+	_ = sub.Subscribe()
+
+	resubscribeSub, err := client.NewSubscription("resubscribe", SubscriptionConfig{ResubscribeOnGap: true})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	_ = resubscribeSub.Subscribe()
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for _, expected := range []string{"6", "9"} {
+		select {
+		case data := <-pubCh:
+			if data != expected {
+				t.Fatalf("expected publication %s, got %s", expected, data)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for publication")
+		}
+	}
+	select {
+	case e := <-gapCh:
+		if e.FromOffset != 7 || e.ToOffset != 8 {
+			t.Fatalf("unexpected gap: %v", e)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for gap event")
+	}
+	select {
+	case req := <-resubscribeCh:
+		if !req.Recover || req.Offset != 6 || req.Epoch != "epoch" {
+			t.Fatalf("expected resubscribe with recovery from offset 6, got %v", req)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for resubscribe")
+	}
+}
+
+func TestPublicationGapPositioned(t *testing.T) {
+	var numSubscribes int32
+	resubscribeCh := make(chan *protocol.SubscribeRequest, 1)
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				switch {
+				case cmd.Subscribe != nil:
+					if atomic.AddInt32(&numSubscribes, 1) == 2 {
+						resubscribeCh <- cmd.Subscribe
+						// Stream is positioned only, server can't recover.
+						return []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Positioned: true, WasRecovering: true, Epoch: "epoch", Offset: 8}}}
+					}
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{Positioned: true, Epoch: "epoch", Offset: 5}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte("6"), Offset: 6}}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte("8"), Offset: 8}}},
+					}
+				case cmd.Unsubscribe != nil:
+					return []*protocol.Reply{{Id: cmd.Id, Unsubscribe: &protocol.UnsubscribeResult{}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{Positioned: true, ResubscribeOnGap: true})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	gapCh := make(chan GapEvent, 1)
+	sub.OnGap(func(e GapEvent) {
+		gapCh <- e
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case req := <-resubscribeCh:
+		if !req.Recover || req.Offset != 6 || req.Epoch != "epoch" {
+			t.Fatalf("expected resubscribe with recovery from offset 6, got %v", req)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for resubscribe")
+	}
+	select {
+	case e := <-gapCh:
+		if e.FromOffset != 7 || e.ToOffset != 8 {
+			t.Fatalf("unexpected gap: %v", e)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for gap event")
+	}
+}
+
+func TestHistoryIterator(t *testing.T) {
+	const top = 7
+	var epoch atomic.Value
+	epoch.Store("epoch")
+	// Server may cap page size below requested limit.
+	var maxPage int32 = 100
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.History == nil {
+					return testConnectHandler(cmd)
+				}
+				req := cmd.History
+				var pubs []*protocol.Publication
+				add := func(o uint64) bool {
+					if len(pubs) >= int(req.Limit) || len(pubs) >= int(atomic.LoadInt32(&maxPage)) {
+						return false
+					}
+					pubs = append(pubs, &protocol.Publication{Data: []byte(strconv.FormatUint(o, 10)), Offset: o})
+					return true
+				}
+				if req.Reverse {
+					o := uint64(top)
+					if req.Since != nil {
+						o = req.Since.Offset - 1
+					}
+					for ; o >= 1 && add(o); o-- {
+					}
+				} else {
+					o := uint64(1)
+					if req.Since != nil {
+						o = req.Since.Offset + 1
+					}
+					for ; o <= top && add(o); o++ {
+					}
+				}
+				return []*protocol.Reply{{Id: cmd.Id, History: &protocol.HistoryResult{
+					Publications: pubs, Epoch: epoch.Load().(string), Offset: top,
+				}}}
+			}), nil
+		},
+	})
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+
+	collect := func(it *HistoryIterator) string {
+		var offsets []string
+		for it.Next() {
+			offsets = append(offsets, string(it.Publication().Data))
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("iteration error: %v", err)
+		}
+		return strings.Join(offsets, ",")
+	}
+	ctx := context.Background()
+	if got := collect(client.HistoryIterator(ctx, "test", WithHistoryLimit(3))); got != "1,2,3,4,5,6,7" {
+		t.Fatalf("unexpected forward iteration: %s", got)
+	}
+	if got := collect(client.HistoryIterator(ctx, "test", WithHistoryLimit(3), WithHistoryReverse(true))); got != "7,6,5,4,3,2,1" {
+		t.Fatalf("unexpected reverse iteration: %s", got)
+	}
+
+	atomic.StoreInt32(&maxPage, 2)
+	if got := collect(client.HistoryIterator(ctx, "test", WithHistoryLimit(3))); got != "1,2,3,4,5,6,7" {
+		t.Fatalf("unexpected forward iteration with short pages: %s", got)
+	}
+	if got := collect(client.HistoryIterator(ctx, "test", WithHistoryLimit(3), WithHistoryReverse(true))); got != "7,6,5,4,3,2,1" {
+		t.Fatalf("unexpected reverse iteration with short pages: %s", got)
+	}
+	atomic.StoreInt32(&maxPage, 100)
+
+	it := client.HistoryIterator(ctx, "test", WithHistoryLimit(3))
+	for i := 0; i < 3; i++ {
+		if !it.Next() {
+			t.Fatalf("unexpected end of iteration: %v", it.Err())
+		}
+	}
+	epoch.Store("new")
+	if it.Next() {
+		t.Fatal("expected iteration to stop on epoch change")
+	}
+	if !errors.Is(it.Err(), ErrHistoryEpochChanged) {
+		t.Fatalf("expected ErrHistoryEpochChanged, got %v", it.Err())
+	}
+}
+
+func TestPresenceTracking(t *testing.T) {
+	info := func(client, user string) *protocol.ClientInfo {
+		return &protocol.ClientInfo{Client: client, User: user}
+	}
+	var transport *testTransport
+	transport = newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+		switch {
+		case cmd.Subscribe != nil:
+			return []*protocol.Reply{
+				{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}},
+				// Join received while presence snapshot is loading.
+				{Push: &protocol.Push{Channel: "test", Join: &protocol.Join{Info: info("c3", "u2")}}},
+			}
+		case cmd.Presence != nil:
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				transport.replyCh <- &protocol.Reply{Id: cmd.Id, Presence: &protocol.PresenceResult{Presence: map[string]*protocol.ClientInfo{
+					"c1": info("c1", "u1"),
+					"c2": info("c2", "u1"),
+				}}}
+				transport.replyCh <- &protocol.Reply{Push: &protocol.Push{Channel: "test", Leave: &protocol.Leave{Info: info("c1", "u1")}}}
+			}()
+			return nil
+		}
+		return testConnectHandler(cmd)
+	})
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return transport, nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{JoinLeave: true, TrackPresence: true})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	rosterCh := make(chan PresenceRoster, 10)
+	sub.OnPresenceChanged(func(e PresenceChangedEvent) {
+		rosterCh <- e.PresenceRoster
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for {
+		select {
+		case roster := <-rosterCh:
+			if len(roster.Clients) != 2 {
+				continue
+			}
+			if _, ok := roster.Clients["c1"]; ok {
+				continue
+			}
+			if len(roster.Users["u1"]) != 1 || roster.Users["u1"][0].Client != "c2" || len(roster.Users["u2"]) != 1 {
+				t.Fatalf("unexpected roster: %v", roster)
+			}
+			if len(sub.PresenceRoster().Clients) != 2 {
+				t.Fatalf("unexpected roster snapshot: %v", sub.PresenceRoster())
+			}
+			return
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for presence roster")
+		}
+	}
+}
+
+func TestPresenceTrackingRetry(t *testing.T) {
+	var numPresence int32
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				switch {
+				case cmd.Subscribe != nil:
+					return []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}}}
+				case cmd.Presence != nil:
+					if atomic.AddInt32(&numPresence, 1) == 1 {
+						return []*protocol.Reply{{Id: cmd.Id, Error: &protocol.Error{Code: 100, Message: "internal server error"}}}
+					}
+					return []*protocol.Reply{{Id: cmd.Id, Presence: &protocol.PresenceResult{Presence: map[string]*protocol.ClientInfo{
+						"c1": {Client: "c1", User: "u1"},
+					}}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{
+		JoinLeave:           true,
+		TrackPresence:       true,
+		ResubscribeStrategy: &ConstantReconnect{Delay: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	errCh := make(chan error, 1)
+	sub.OnError(func(e SubscriptionErrorEvent) {
+		errCh <- e.Error
+	})
+	rosterCh := make(chan PresenceRoster, 10)
+	sub.OnPresenceChanged(func(e PresenceChangedEvent) {
+		rosterCh <- e.PresenceRoster
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		var presenceErr SubscriptionPresenceError
+		if !errors.As(err, &presenceErr) {
+			t.Fatalf("expected SubscriptionPresenceError, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for presence error")
+	}
+	for _, expected := range []int{0, 1} {
+		select {
+		case roster := <-rosterCh:
+			if len(roster.Clients) != expected {
+				t.Fatalf("expected %d clients in roster, got %v", expected, roster)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for presence roster")
+		}
+	}
+}
+
+func TestEventChannels(t *testing.T) {
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					replies := []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}}}
+					for i := 1; i <= 3; i++ {
+						replies = append(replies, &protocol.Reply{Push: &protocol.Push{
+							Channel: cmd.Subscribe.Channel,
+							Pub:     &protocol.Publication{Data: []byte(strconv.Itoa(i))},
+						}})
+					}
+					return replies
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	events := client.Events(16)
+
+	sub, err := client.NewSubscription("test")
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubs := sub.Publications(1, ChannelDropOldest)
+	handled := make(chan struct{}, 3)
+	sub.OnPublication(func(PublicationEvent) {
+		handled <- struct{}{}
+	})
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-handled:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for publication")
+		}
+	}
+	if pub := <-pubs; string(pub.Data) != "3" {
+		t.Fatalf("expected the newest publication in channel, got %s", pub.Data)
+	}
+
+	client.Close()
+	var connecting, connected bool
+	for ev := range events {
+		switch ev.(type) {
+		case ConnectingEvent:
+			connecting = true
+		case ConnectedEvent:
+			connected = true
+		}
+	}
+	if !connecting || !connected {
+		t.Fatalf("expected connecting and connected events, got %v %v", connecting, connected)
+	}
+	if _, ok := <-pubs; ok {
+		t.Fatal("expected publications channel to be closed")
+	}
+}
+
+func TestRemoveSubscriptionClosesPublications(t *testing.T) {
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(testConnectHandler), nil
+		},
+	})
+	defer client.Close()
+	sub, err := client.NewSubscription("test")
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	pubs := sub.Publications(1)
+	if err := client.RemoveSubscription(sub); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-pubs:
+		if ok {
+			t.Fatal("unexpected publication")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected publications channel to be closed")
+	}
+}
+
+func TestMultipleHandlers(t *testing.T) {
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					return []*protocol.Reply{
+						{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}},
+						{Push: &protocol.Push{Channel: cmd.Subscribe.Channel, Pub: &protocol.Publication{Data: []byte("{}")}}},
+					}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	connected := make(chan struct{}, 2)
+	client.AddConnectedHandler(func(ConnectedEvent) { connected <- struct{}{} })
+	client.OnConnected(func(ConnectedEvent) { connected <- struct{}{} })
+
+	sub, err := client.NewSubscription("test")
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	calls := make(chan string, 4)
+	sub.AddPublicationHandler(func(PublicationEvent) { calls <- "first" })
+	sub.OnPublication(func(PublicationEvent) { calls <- "replaced" })
+	removeThird := sub.AddPublicationHandler(func(PublicationEvent) { calls <- "third" })
+	sub.OnPublication(func(PublicationEvent) { calls <- "setter" })
+	sub.AddPublicationHandler(func(PublicationEvent) { calls <- "fourth" })
+	removeThird()
+	removeThird()
+
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for connected event")
+		}
+	}
+	for _, expected := range []string{"first", "setter", "fourth"} {
+		select {
+		case call := <-calls:
+			if call != expected {
+				t.Fatalf("expected %s handler call, got %s", expected, call)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for publication")
+		}
+	}
+	select {
+	case call := <-calls:
+		t.Fatalf("unexpected %s handler call", call)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTokenContextAndRefreshDelay(t *testing.T) {
+	var tokenCtx context.Context
+	client := NewJsonClient("ws://localhost:8000/connection/websocket", Config{
+		GetToken: func(e ConnectionTokenEvent) (string, error) {
+			tokenCtx = e.Context
+			return "token", nil
+		},
+		GetTokenTimeout:   time.Minute,
+		TokenRefreshRatio: 0.8,
+	})
+	if _, err := client.refreshToken(); err != nil {
+		t.Fatalf("error on refresh token: %v", err)
+	}
+	if _, ok := tokenCtx.Deadline(); !ok {
+		t.Fatal("expected token context with deadline")
+	}
+	for i := 0; i < 100; i++ {
+		delay := client.refreshDelay(100)
+		if delay < 70*time.Second || delay > 80*time.Second {
+			t.Fatalf("unexpected refresh delay %s", delay)
+		}
+	}
+
+	ctx, cancel := client.tokenContext()
+	defer cancel()
+	client.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected token context cancelled on close")
+	}
+}
+
+func TestConnectWithExpiringToken(t *testing.T) {
+	expiring, err := jwt.ConnectionToken(jwt.ConnectionClaims{Sub: "42", Exp: time.Now().Unix() + 1}, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectTokens := make(chan string, 2)
+	factory := func(string, protocol.Type, Config) (Transport, error) {
+		return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+			if cmd.Connect != nil {
+				connectTokens <- cmd.Connect.Token
+			}
+			return testConnectHandler(cmd)
+		}), nil
+	}
+
+	client := NewJsonClient("memory://test", Config{
+		Token:            expiring,
+		TransportFactory: factory,
+	})
+	errCh := make(chan error, 1)
+	client.OnError(func(e ErrorEvent) {
+		errCh <- e.Error
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		var expiringErr TokenExpiringError
+		if !errors.As(err, &expiringErr) {
+			t.Fatalf("expected TokenExpiringError, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for error")
+	}
+	if token := <-connectTokens; token != expiring {
+		t.Fatalf("expected connect with static token, got %s", token)
+	}
+	client.Close()
+
+	client = NewJsonClient("memory://test", Config{
+		Token: expiring,
+		GetToken: func(ConnectionTokenEvent) (string, error) {
+			return "fresh", nil
+		},
+		TransportFactory: factory,
+	})
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case token := <-connectTokens:
+		if token != "fresh" {
+			t.Fatalf("expected connect with refreshed token, got %s", token)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for connect")
+	}
+}
+
+func TestSubscriptionTokenCache(t *testing.T) {
+	subscribeTokens := make(chan string, 10)
+	var batchCalls, tokenCalls int32
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					subscribeTokens <- cmd.Subscribe.Token
+					return []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}}}
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+		GetSubscriptionTokens: func(e SubscriptionTokensEvent) (map[string]string, error) {
+			atomic.AddInt32(&batchCalls, 1)
+			tokens := make(map[string]string)
+			for _, ch := range e.Channels {
+				if ch != "missing" {
+					tokens[ch] = "batch-" + ch
+				}
+			}
+			return tokens, nil
+		},
+	})
+	defer client.Close()
+
+	channels := []string{"a", "b", "missing"}
+	for _, ch := range channels {
+		sub, err := client.NewSubscription(ch, SubscriptionConfig{
+			GetToken: func(e SubscriptionTokenEvent) (string, error) {
+				atomic.AddInt32(&tokenCalls, 1)
+				return "single-" + e.Channel, nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("error on new subscription: %v", err)
+		}
+		_ = sub.Subscribe()
+	}
+
+	expected := map[string]bool{"batch-a": true, "batch-b": true, "single-missing": true}
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := client.Connect(); err != nil {
+			t.Fatalf("error on connect: %v", err)
+		}
+		for range channels {
+			select {
+			case token := <-subscribeTokens:
+				if !expected[token] {
+					t.Fatalf("unexpected subscribe token %s", token)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("timeout waiting for subscribe")
+			}
+		}
+		if err := client.Disconnect(); err != nil {
+			t.Fatalf("error on disconnect: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&batchCalls); n != 1 {
+		t.Fatalf("expected one batch token call, got %d", n)
+	}
+	if n := atomic.LoadInt32(&tokenCalls); n != 1 {
+		t.Fatalf("expected one subscription token call, got %d", n)
+	}
+}
+
+func TestSubscriptionTokenCacheInvalidated(t *testing.T) {
+	subscribeTokens := make(chan string, 10)
+	var numConnects, numSubscribes, tokenCalls int32
+	transports := make(chan *testTransport, 10)
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			transport := newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Connect != nil {
+					// New client ID on every connection.
+					n := atomic.AddInt32(&numConnects, 1)
+					return []*protocol.Reply{{Id: cmd.Id, Connect: &protocol.ConnectResult{Client: "c" + strconv.Itoa(int(n))}}}
+				}
+				if cmd.Subscribe != nil {
+					subscribeTokens <- cmd.Subscribe.Token
+					if atomic.AddInt32(&numSubscribes, 1) == 2 {
+						return []*protocol.Reply{{Id: cmd.Id, Error: &protocol.Error{Code: 100, Message: "internal server error", Temporary: true}}}
+					}
+					return []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}}}
+				}
+				return testConnectHandler(cmd)
+			})
+			transports <- transport
+			return transport, nil
+		},
+		ReconnectStrategy: &ConstantReconnect{Delay: 10 * time.Millisecond},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test", SubscriptionConfig{
+		GetToken: func(SubscriptionTokenEvent) (string, error) {
+			return "token-" + strconv.Itoa(int(atomic.AddInt32(&tokenCalls, 1))), nil
+		},
+		ResubscribeStrategy: &ConstantReconnect{Delay: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	subscribedCh := make(chan struct{}, 1)
+	sub.OnSubscribed(func(SubscribedEvent) {
+		subscribedCh <- struct{}{}
+	})
+	_ = sub.Subscribe()
+
+	expectToken := func(expected string) {
+		select {
+		case token := <-subscribeTokens:
+			if token != expected {
+				t.Fatalf("expected subscribe token %s, got %s", expected, token)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for subscribe")
+		}
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	expectToken("token-1")
+	select {
+	case <-subscribedCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for subscribed")
+	}
+	// Client reconnects with new client ID.
+	_ = (<-transports).Close()
+	// Token of previous client ID is not reused.
+	expectToken("token-2")
+	// Token is not reused after failed subscribe.
+	expectToken("token-3")
+}
+
+func TestConfigValidate(t *testing.T) {
+	client := NewJsonClient("ftp://localhost", Config{})
+	defer client.Close()
+	var configErr ConfigError
+	if err := client.Connect(); !errors.As(err, &configErr) || configErr.Field != "endpoint" {
+		t.Fatalf("expected endpoint ConfigError, got %v", err)
+	}
+
+	client = NewJsonClient("ws://localhost:8000/connection/websocket", Config{ReadTimeout: -time.Second})
+	defer client.Close()
+	if err := client.Connect(); !errors.As(err, &configErr) || configErr.Field != "ReadTimeout" {
+		t.Fatalf("expected ReadTimeout ConfigError, got %v", err)
+	}
+
+	if err := (Config{Transports: []TransportEndpoint{{Transport: "unknown", Endpoint: "ws://localhost"}}}).Validate(); !errors.As(err, &configErr) {
+		t.Fatalf("expected ConfigError for unknown transport, got %v", err)
+	}
+
+	client = NewJsonClient("ws://localhost:8000/connection/websocket", Config{})
+	defer client.Close()
+	if _, err := client.NewSubscription("test", SubscriptionConfig{TrackPresence: true}); !errors.As(err, &configErr) || configErr.Field != "TrackPresence" {
+		t.Fatalf("expected TrackPresence ConfigError, got %v", err)
+	}
+	if _, err := client.NewSubscription("test", SubscriptionConfig{TagsFilter: &TagsFilter{Key: "k", Cmp: "gt"}}); !errors.As(err, &configErr) || configErr.Field != "TagsFilter" {
+		t.Fatalf("expected TagsFilter ConfigError, got %v", err)
+	}
+	if _, err := client.NewSubscription("test", SubscriptionConfig{Recoverable: true}); !errors.As(err, &configErr) || configErr.Field != "Recoverable" {
+		t.Fatalf("expected Recoverable ConfigError, got %v", err)
+	}
+	if _, err := client.NewSubscription("test", SubscriptionConfig{Recoverable: true, Positioned: true, TagsFilter: TagsEq("k", "v")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+type testMetrics struct {
+	attempts, connected, disconnected, errors int32
+}
+
+func (m *testMetrics) ConnectAttempt()     { atomic.AddInt32(&m.attempts, 1) }
+func (m *testMetrics) Connected()          { atomic.AddInt32(&m.connected, 1) }
+func (m *testMetrics) Disconnected(uint32) { atomic.AddInt32(&m.disconnected, 1) }
+func (m *testMetrics) Error(error)         { atomic.AddInt32(&m.errors, 1) }
+
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestNewClient(t *testing.T) {
+	var configErr ConfigError
+	if _, err := NewClient("", Config{}); !errors.As(err, &configErr) {
+		t.Fatalf("expected ConfigError for empty endpoint, got %v", err)
+	}
+	if _, err := NewClient("localhost:8000", Config{}); !errors.As(err, &configErr) {
+		t.Fatalf("expected ConfigError for malformed endpoint, got %v", err)
+	}
+	if _, err := NewClient("ws://localhost:8000", Config{}, WithProtocolType("xml")); !errors.As(err, &configErr) {
+		t.Fatalf("expected ConfigError for unknown protocol, got %v", err)
+	}
+
+	metrics := &testMetrics{}
+	logger := &testLogger{}
+	var protocolType protocol.Type
+	client, err := NewClient("ws://localhost:8000/connection/websocket", Config{},
+		WithProtocolType(protocol.TypeProtobuf),
+		WithTransports(TransportEndpoint{
+			Transport: "memory",
+			Endpoint:  "memory://test",
+			Factory: func(_ string, pt protocol.Type, _ Config) (Transport, error) {
+				protocolType = pt
+				return newTestTransport(testConnectHandler), nil
+			},
+		}),
+		WithReconnectStrategy(&ConstantReconnect{Delay: time.Millisecond}),
+		WithMetrics(metrics),
+		WithLogger(logger),
+	)
+	if err != nil {
+		t.Fatalf("error on new client: %v", err)
+	}
+	connected := make(chan struct{})
+	client.OnConnected(func(ConnectedEvent) {
+		close(connected)
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	select {
+	case <-connected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for connect")
+	}
+	client.Close()
+	if protocolType != protocol.TypeProtobuf {
+		t.Fatalf("expected protobuf protocol, got %s", protocolType)
+	}
+	if atomic.LoadInt32(&metrics.attempts) != 1 || atomic.LoadInt32(&metrics.connected) != 1 || atomic.LoadInt32(&metrics.disconnected) != 1 {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.lines) != 2 {
+		t.Fatalf("expected connect and disconnect log lines, got %v", logger.lines)
+	}
+}
+
+type failingPositionStore struct {
+	*MemoryPositionStore
+}
+
+func (failingPositionStore) Channels() ([]string, error) {
+	return nil, errors.New("boom")
+}
+
+// stateMetrics calls Client from Error to check it's not called with lock held.
+type stateMetrics struct {
+	testMetrics
+	client atomic.Value
+}
+
+func (m *stateMetrics) Error(err error) {
+	if c, ok := m.client.Load().(*Client); ok {
+		_ = c.State()
+	}
+	m.testMetrics.Error(err)
+}
+
+func TestMetricsObservedOnce(t *testing.T) {
+	var numTransports int32
+	var transport *testTransport
+	metrics := &stateMetrics{}
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			if atomic.AddInt32(&numTransports, 1) > 1 {
+				return nil, errors.New("unavailable")
+			}
+			transport = newTestTransport(testConnectHandler)
+			return transport, nil
+		},
+		ReconnectStrategy: &ConstantReconnect{Delay: time.Hour},
+		PositionStore:     failingPositionStore{NewMemoryPositionStore()},
+		Metrics:           metrics,
+	})
+	metrics.client.Store(client)
+	defer client.Close()
+	connecting := make(chan struct{}, 2)
+	client.OnConnecting(func(ConnectingEvent) {
+		connecting <- struct{}{}
+	})
+	disconnected := make(chan struct{}, 1)
+	client.OnDisconnected(func(DisconnectedEvent) {
+		disconnected <- struct{}{}
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	waitCh := func(ch chan struct{}, what string) {
+		select {
+		case <-ch:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+	waitCh(connecting, "connecting")
+	waitConnected := time.After(3 * time.Second)
+	for client.State() != StateConnected {
+		select {
+		case <-waitConnected:
+			t.Fatal("timeout waiting for connect")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	_ = transport.Close()
+	waitCh(connecting, "reconnecting")
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("error on disconnect: %v", err)
+	}
+	waitCh(disconnected, "disconnect")
+	if n := atomic.LoadInt32(&metrics.disconnected); n != 1 {
+		t.Fatalf("expected one disconnect observed, got %d", n)
+	}
+	if n := atomic.LoadInt32(&metrics.errors); n == 0 {
+		t.Fatal("expected position store error observed")
+	}
+}
+
+func TestHandlersChangedWhileDispatching(t *testing.T) {
+	const numPubs = 200
+	client := NewJsonClient("memory://test", Config{
+		TransportFactory: func(string, protocol.Type, Config) (Transport, error) {
+			return newTestTransport(func(cmd *protocol.Command) []*protocol.Reply {
+				if cmd.Subscribe != nil {
+					replies := []*protocol.Reply{{Id: cmd.Id, Subscribe: &protocol.SubscribeResult{}}}
+					for i := 0; i < numPubs; i++ {
+						replies = append(replies, &protocol.Reply{Push: &protocol.Push{
+							Channel: cmd.Subscribe.Channel,
+							Pub:     &protocol.Publication{Data: []byte("{}")},
+						}})
+					}
+					return replies
+				}
+				return testConnectHandler(cmd)
+			}), nil
+		},
+	})
+	defer client.Close()
+
+	sub, err := client.NewSubscription("test")
+	if err != nil {
+		t.Fatalf("error on new subscription: %v", err)
+	}
+	received := make(chan struct{}, numPubs)
+	sub.OnPublication(func(PublicationEvent) {
+		received <- struct{}{}
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			removePub := sub.AddPublicationHandler(func(PublicationEvent) {})
+			removeConnected := client.AddConnectedHandler(func(ConnectedEvent) {})
+			removePub()
+			removeConnected()
+		}
+	}()
+
+	_ = sub.Subscribe()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("error on connect: %v", err)
+	}
+	for i := 0; i < numPubs; i++ {
+		select {
+		case <-received:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for publication, %d received", i)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
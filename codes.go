@@ -5,6 +5,9 @@ const (
 	disconnectedUnauthorized     uint32 = 1
 	disconnectBadProtocol        uint32 = 2
 	disconnectMessageSizeLimit   uint32 = 3
+	disconnectedReconnectStopped uint32 = 4
+	disconnectedMaxAttempts      uint32 = 5
+	disconnectedMaxDuration      uint32 = 6
 )
 
 const (
@@ -18,10 +21,13 @@ const (
 const (
 	subscribingSubscribeCalled uint32 = 0
 	subscribingTransportClosed uint32 = 1
+	subscribingPublicationGap  uint32 = 2
+	subscribingCatchUpError    uint32 = 3
 )
 
 const (
-	unsubscribedUnsubscribeCalled uint32 = 0
-	unsubscribedUnauthorized      uint32 = 1
-	unsubscribedClientClosed      uint32 = 2
+	unsubscribedUnsubscribeCalled  uint32 = 0
+	unsubscribedUnauthorized       uint32 = 1
+	unsubscribedClientClosed       uint32 = 2
+	unsubscribedResubscribeStopped uint32 = 3
 )
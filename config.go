@@ -3,6 +3,7 @@ package centrifuge
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"time"
@@ -14,6 +15,24 @@ type Config struct {
 	Token string
 	// GetToken called to get or refresh connection token.
 	GetToken func(ConnectionTokenEvent) (string, error)
+	// GetTokenTimeout is a deadline of context passed to GetToken and
+	// SubscriptionConfig.GetToken in token events. Context is also cancelled
+	// when Client closed.
+	// Zero value means 10 * time.Second.
+	GetTokenTimeout time.Duration
+	// TokenRefreshRatio is a portion of connection and subscription token TTL
+	// after which token refreshed, e.g. 0.8 refreshes token at 80% of TTL. A
+	// random jitter up to 10% of TTL is subtracted from the delay to spread
+	// refreshes of many clients, so slow GetToken calls complete before token
+	// expires.
+	// Zero value means refreshing at TTL without jitter.
+	TokenRefreshRatio float64
+	// TokenExpiryLead is a time before exp claim of connection JWT when token
+	// considered close to expiring. Before connecting with such token Client
+	// calls GetToken to get a fresh one, or emits TokenExpiringError when
+	// GetToken not set.
+	// Zero value means 10 * time.Second.
+	TokenExpiryLead time.Duration
 	// Data is an arbitrary data which can be sent to a server in a Connect command.
 	// Make sure it's a valid JSON when using JSON protocol client.
 	Data []byte
@@ -55,4 +74,143 @@ type Config struct {
 	CookieJar http.CookieJar
 	// Header specifies custom HTTP Header to send.
 	Header http.Header
+	// TransportFactory allows using custom Transport implementation. Client
+	// passes connection endpoint to it as is.
+	// Zero value means selecting transport by endpoint scheme: Websocket for
+	// ws:// and wss://, HTTP-streaming for http:// and https://. Set it to
+	// NewSSETransport to connect over Server-Sent Events.
+	TransportFactory TransportFactory
+	// EmulationEndpoint is a URL of Centrifugo bidirectional emulation endpoint
+	// used by HTTP-streaming and SSE transports to send commands to a server.
+	// Zero value means /emulation path on the connection endpoint host.
+	EmulationEndpoint string
+	// Transports is an ordered list of transports to connect with. Client starts
+	// with the first one, sticks to a transport which connected successfully and
+	// falls back to the next one after TransportMaxFailures consecutive failed
+	// connection attempts. When set, endpoint passed to Client constructor and
+	// TransportFactory are not used.
+	Transports []TransportEndpoint
+	// TransportMaxFailures is a number of consecutive failed connection attempts
+	// after which Client switches to the next transport from Transports.
+	// Zero value means 3.
+	TransportMaxFailures int
+	// ReconnectStrategy allows customizing delays between reconnect attempts.
+	// Zero value means BackoffReconnect with 200ms min delay, 20s max delay,
+	// factor 2 and jitter.
+	ReconnectStrategy ReconnectStrategy
+	// MaxReconnectAttempts limits a number of consecutive failed connection
+	// attempts. When reached Client moves to disconnected state with code 5.
+	// Zero value means no limit.
+	MaxReconnectAttempts int
+	// MaxReconnectDuration limits time Client spends in connecting state trying
+	// to connect. When reached Client moves to disconnected state with code 6.
+	// Zero value means no limit.
+	MaxReconnectDuration time.Duration
+	// WriteBatchInterval enables batching of commands in Websocket transport:
+	// commands written during the interval are sent to a server in one frame
+	// (JSON commands separated by new line, Protobuf commands length-delimited).
+	// This reduces number of frames and syscalls under high publish rate at the
+	// cost of latency up to the interval. Zero value means no batching.
+	WriteBatchInterval time.Duration
+	// WriteBatchMaxSize is a maximum number of commands in one frame when
+	// WriteBatchInterval is set. Batch is sent immediately when full.
+	// Zero value means 64.
+	WriteBatchMaxSize int
+	// ResubscribeBatchSize limits a number of subscribe commands Client sends at
+	// once when resubscribing after reconnect. Next batch is sent after replies
+	// to all commands of the previous batch received.
+	// Zero value means resubscribing to all subscriptions at once.
+	ResubscribeBatchSize int
+	// ResubscribeBatchInterval is a minimal delay between resubscribe batches.
+	// Zero value means no delay.
+	ResubscribeBatchInterval time.Duration
+	// ResubscribeTokenConcurrency is a number of parallel SubscriptionConfig.GetToken
+	// calls while resubscribing, so GetToken must be safe for concurrent use.
+	// Zero value means 8.
+	ResubscribeTokenConcurrency int
+	// OutboundQueueSize is a capacity of queue of commands waiting to be written
+	// to connection. Commands are written by a dedicated goroutine, so callers
+	// do not wait for network while there is a space in queue.
+	// Zero value means 1024.
+	OutboundQueueSize int
+	// OutboundQueueOverflow defines what happens when outbound queue is full.
+	// Zero value means OverflowBlock.
+	OutboundQueueOverflow OverflowPolicy
+	// GetSubscriptionTokens is an optional callback to get tokens for many
+	// subscriptions at once. When set, Client calls it on resubscribe with all
+	// channels which subscriptions have SubscriptionConfig.GetToken set and no
+	// valid cached token. Channels missing in returned map get tokens over
+	// SubscriptionConfig.GetToken.
+	GetSubscriptionTokens func(SubscriptionTokensEvent) (map[string]string, error)
+	// Logger receives Client log messages about connection state changes and
+	// errors. Zero value means no logging.
+	Logger Logger
+	// Metrics allows collecting Client metrics. Zero value means no metrics.
+	Metrics Metrics
+	// PositionStore allows persisting stream positions of recoverable
+	// subscriptions to recover missed publications after process restart.
+	// See NewFilePositionStore.
+	PositionStore PositionStore
+}
+
+// Validate checks Config for invalid and contradictory settings. It returns
+// ConfigError describing the first problem found. Client constructors call
+// Validate, Client.Connect returns its error.
+func (c Config) Validate() error {
+	durations := []struct {
+		field string
+		value time.Duration
+	}{
+		{"ReadTimeout", c.ReadTimeout},
+		{"WriteTimeout", c.WriteTimeout},
+		{"HandshakeTimeout", c.HandshakeTimeout},
+		{"MaxServerPingDelay", c.MaxServerPingDelay},
+		{"MaxReconnectDuration", c.MaxReconnectDuration},
+		{"WriteBatchInterval", c.WriteBatchInterval},
+		{"ResubscribeBatchInterval", c.ResubscribeBatchInterval},
+		{"GetTokenTimeout", c.GetTokenTimeout},
+		{"TokenExpiryLead", c.TokenExpiryLead},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return ConfigError{Field: d.field, Reason: "must not be negative"}
+		}
+	}
+	numbers := []struct {
+		field string
+		value int
+	}{
+		{"TransportMaxFailures", c.TransportMaxFailures},
+		{"MaxReconnectAttempts", c.MaxReconnectAttempts},
+		{"WriteBatchMaxSize", c.WriteBatchMaxSize},
+		{"ResubscribeBatchSize", c.ResubscribeBatchSize},
+		{"ResubscribeTokenConcurrency", c.ResubscribeTokenConcurrency},
+		{"OutboundQueueSize", c.OutboundQueueSize},
+	}
+	for _, n := range numbers {
+		if n.value < 0 {
+			return ConfigError{Field: n.field, Reason: "must not be negative"}
+		}
+	}
+	if c.TokenRefreshRatio < 0 || c.TokenRefreshRatio >= 1 {
+		return ConfigError{Field: "TokenRefreshRatio", Reason: "must be in [0, 1) range"}
+	}
+	if c.OutboundQueueOverflow < OverflowBlock || c.OutboundQueueOverflow > OverflowError {
+		return ConfigError{Field: "OutboundQueueOverflow", Reason: "unknown overflow policy"}
+	}
+	for _, te := range c.Transports {
+		if te.Endpoint == "" {
+			return ConfigError{Field: "Transports", Reason: "endpoint required"}
+		}
+		if te.Factory != nil {
+			continue
+		}
+		if _, ok := transportFactories[te.Transport]; !ok {
+			return ConfigError{Field: "Transports", Reason: fmt.Sprintf("unsupported transport: %s", te.Transport)}
+		}
+		if err := validateEndpoint(te.Endpoint); err != nil {
+			return ConfigError{Field: "Transports", Reason: err.Error()}
+		}
+	}
+	return nil
 }
@@ -3,6 +3,7 @@ package centrifuge
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
@@ -20,8 +21,27 @@ var (
 	// that server does not allow subscribing to the same channel twice for
 	// the same connection.
 	ErrDuplicateSubscription = errors.New("duplicate subscription")
+	// ErrOutboundQueueFull returned if command can't be sent because queue of
+	// commands waiting to be written to connection is full.
+	ErrOutboundQueueFull = errors.New("outbound queue full")
+	// ErrHistoryEpochChanged returned by HistoryIterator if channel stream
+	// epoch changed during iteration.
+	ErrHistoryEpochChanged = errors.New("history epoch changed")
 )
 
+// ConfigError returned when Config or SubscriptionConfig has invalid or
+// contradictory settings.
+type ConfigError struct {
+	// Field is a name of invalid option.
+	Field string
+	// Reason describes the problem.
+	Reason string
+}
+
+func (c ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", c.Field, c.Reason)
+}
+
 type TransportError struct {
 	Err error
 }
@@ -30,6 +50,10 @@ func (t TransportError) Error() string {
 	return fmt.Sprintf("transport error: %v", t.Err)
 }
 
+func (t TransportError) Unwrap() error {
+	return t.Err
+}
+
 type ConnectError struct {
 	Err error
 }
@@ -38,6 +62,10 @@ func (c ConnectError) Error() string {
 	return fmt.Sprintf("connect error: %v", c.Err)
 }
 
+func (c ConnectError) Unwrap() error {
+	return c.Err
+}
+
 type RefreshError struct {
 	Err error
 }
@@ -46,6 +74,32 @@ func (r RefreshError) Error() string {
 	return fmt.Sprintf("refresh error: %v", r.Err)
 }
 
+func (r RefreshError) Unwrap() error {
+	return r.Err
+}
+
+// TokenExpiringError emitted when Client connects with connection token which
+// is expired or close to expiring and Config.GetToken is not set.
+type TokenExpiringError struct {
+	ExpiresAt time.Time
+}
+
+func (t TokenExpiringError) Error() string {
+	return fmt.Sprintf("connection token expires at %s", t.ExpiresAt.Format(time.RFC3339))
+}
+
+type SubscriptionTokensError struct {
+	Err error
+}
+
+func (s SubscriptionTokensError) Error() string {
+	return fmt.Sprintf("subscription tokens error: %v", s.Err)
+}
+
+func (s SubscriptionTokensError) Unwrap() error {
+	return s.Err
+}
+
 type SubscriptionSubscribeError struct {
 	Err error
 }
@@ -54,6 +108,10 @@ func (s SubscriptionSubscribeError) Error() string {
 	return fmt.Sprintf("subscribe error: %v", s.Err)
 }
 
+func (s SubscriptionSubscribeError) Unwrap() error {
+	return s.Err
+}
+
 type SubscriptionRefreshError struct {
 	Err error
 }
@@ -61,3 +119,48 @@ type SubscriptionRefreshError struct {
 func (s SubscriptionRefreshError) Error() string {
 	return fmt.Sprintf("refresh error: %v", s.Err)
 }
+
+func (s SubscriptionRefreshError) Unwrap() error {
+	return s.Err
+}
+
+type SubscriptionCatchUpError struct {
+	Err error
+}
+
+func (s SubscriptionCatchUpError) Error() string {
+	return fmt.Sprintf("history catch-up error: %v", s.Err)
+}
+
+func (s SubscriptionCatchUpError) Unwrap() error {
+	return s.Err
+}
+
+type SubscriptionPresenceError struct {
+	Err error
+}
+
+func (s SubscriptionPresenceError) Error() string {
+	return fmt.Sprintf("presence error: %v", s.Err)
+}
+
+func (s SubscriptionPresenceError) Unwrap() error {
+	return s.Err
+}
+
+// RetryAfterError may be returned by TransportFactory (or Transport.Write of the
+// first command) to ask Client to wait at least RetryAfter before the next
+// connection attempt. Transports shipped with this package return it when a
+// server responds with Retry-After header.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (r *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %s)", r.Err, r.RetryAfter)
+}
+
+func (r *RetryAfterError) Unwrap() error {
+	return r.Err
+}
@@ -0,0 +1,168 @@
+package centrifuge
+
+// ChannelOverflow defines what happens when a buffer of event channel is
+// full.
+type ChannelOverflow int
+
+const (
+	// ChannelBlock blocks event dispatching until there is a space in
+	// channel. Note, this also blocks all other event handlers of Client.
+	ChannelBlock ChannelOverflow = iota
+	// ChannelDropNewest drops new event.
+	ChannelDropNewest
+	// ChannelDropOldest drops the oldest buffered event to make a space for
+	// new one.
+	ChannelDropOldest
+)
+
+// Event is a value sent to channel returned by Client.Events. It's one of:
+// ConnectingEvent, ConnectedEvent, DisconnectedEvent, ErrorEvent,
+// MessageEvent, ServerSubscribingEvent, ServerSubscribedEvent,
+// ServerUnsubscribedEvent, ServerPublicationEvent, ServerJoinEvent,
+// ServerLeaveEvent, TransportSwitchedEvent.
+type Event interface{}
+
+// eventChan is a channel events are sent to from callback queue goroutine.
+type eventChan struct {
+	ch       chan Event
+	overflow ChannelOverflow
+	closed   bool
+}
+
+func (c *eventChan) send(ev Event) {
+	if c.closed {
+		return
+	}
+	switch c.overflow {
+	case ChannelDropNewest:
+		select {
+		case c.ch <- ev:
+		default:
+		}
+	case ChannelDropOldest:
+		for {
+			select {
+			case c.ch <- ev:
+				return
+			default:
+			}
+			select {
+			case <-c.ch:
+			default:
+			}
+		}
+	default:
+		c.ch <- ev
+	}
+}
+
+func (c *eventChan) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.ch)
+	}
+}
+
+func sendEvent(chans []*eventChan, ev Event) {
+	for _, c := range chans {
+		c.send(ev)
+	}
+}
+
+// publicationChan is a channel publications of Subscription are sent to.
+type publicationChan struct {
+	ch       chan PublicationEvent
+	overflow ChannelOverflow
+	closed   bool
+}
+
+func (c *publicationChan) send(ev PublicationEvent) {
+	if c.closed {
+		return
+	}
+	switch c.overflow {
+	case ChannelDropNewest:
+		select {
+		case c.ch <- ev:
+		default:
+		}
+	case ChannelDropOldest:
+		for {
+			select {
+			case c.ch <- ev:
+				return
+			default:
+			}
+			select {
+			case <-c.ch:
+			default:
+			}
+		}
+	default:
+		c.ch <- ev
+	}
+}
+
+func (c *publicationChan) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.ch)
+	}
+}
+
+// Events returns channel with all Client events, an alternative to setting
+// event handlers. Channel buffer is bufferSize, overflow allows choosing what
+// happens when buffer is full (ChannelBlock by default). Channel is closed
+// after Client closed. Like event handlers it should be called before Connect.
+func (c *Client) Events(bufferSize int, overflow ...ChannelOverflow) <-chan Event {
+	ec := &eventChan{ch: make(chan Event, bufferSize)}
+	if len(overflow) > 0 {
+		ec.overflow = overflow[0]
+	}
+	c.events.registry.mu.Lock()
+	c.events.chans = append(c.events.chans, ec)
+	c.events.rebuild()
+	c.events.registry.mu.Unlock()
+	return ec.ch
+}
+
+// Publications returns channel with Subscription publications, an
+// alternative to OnPublication handler. Channel buffer is bufferSize,
+// overflow allows choosing what happens when buffer is full (ChannelBlock by
+// default). Channel is closed after Client closed or Subscription removed with
+// Client.RemoveSubscription. Like event handlers it should be called before
+// Subscribe.
+func (s *Subscription) Publications(bufferSize int, overflow ...ChannelOverflow) <-chan PublicationEvent {
+	pc := &publicationChan{ch: make(chan PublicationEvent, bufferSize)}
+	if len(overflow) > 0 {
+		pc.overflow = overflow[0]
+	}
+	s.events.registry.mu.Lock()
+	s.events.pubChans = append(s.events.pubChans, pc)
+	s.events.rebuild()
+	s.events.registry.mu.Unlock()
+	return pc.ch
+}
+
+// closeEventChans closes event channels of Client and subs. Must be called
+// from callback queue goroutine.
+func (c *Client) closeEventChans(subs []*Subscription) {
+	c.events.registry.mu.Lock()
+	for _, ec := range c.events.chans {
+		ec.close()
+	}
+	c.events.registry.mu.Unlock()
+	for _, s := range subs {
+		s.closePublicationChans()
+	}
+}
+
+// closePublicationChans closes publication channels of Subscription. Must be
+// called from callback queue goroutine.
+func (s *Subscription) closePublicationChans() {
+	s.events.registry.mu.Lock()
+	defer s.events.registry.mu.Unlock()
+	for _, pc := range s.events.pubChans {
+		pc.close()
+	}
+}
@@ -12,7 +12,7 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/centrifugal/centrifuge-go"
-	"github.com/golang-jwt/jwt"
+	"github.com/centrifugal/centrifuge-go/jwt"
 )
 
 // In real life clients should never know secret key. This is only for example
@@ -22,11 +22,7 @@ const exampleTokenHmacSecret = "secret"
 func connToken(user string, exp int64) string {
 	// NOTE that JWT must be generated on backend side of your application!
 	// Here we are generating it on client side only for example simplicity.
-	claims := jwt.MapClaims{"sub": user}
-	if exp > 0 {
-		claims["exp"] = exp
-	}
-	t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(exampleTokenHmacSecret))
+	t, err := jwt.ConnectionToken(jwt.ConnectionClaims{Sub: user, Exp: exp}, []byte(exampleTokenHmacSecret))
 	if err != nil {
 		panic(err)
 	}
@@ -96,6 +92,7 @@ func main() {
 	}
 
 	sub, err := client.NewSubscription("chat:index", centrifuge.SubscriptionConfig{
+		Positioned:  true,
 		Recoverable: true,
 		JoinLeave:   true,
 	})
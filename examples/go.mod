@@ -4,7 +4,4 @@ go 1.16
 
 replace github.com/centrifugal/centrifuge-go => ../
 
-require (
-	github.com/centrifugal/centrifuge-go v0.3.0
-	github.com/golang-jwt/jwt v3.2.2+incompatible
-)
+require github.com/centrifugal/centrifuge-go v0.3.0
@@ -7,17 +7,13 @@ import (
 	"time"
 
 	"github.com/centrifugal/centrifuge-go"
-	"github.com/golang-jwt/jwt"
+	"github.com/centrifugal/centrifuge-go/jwt"
 )
 
 func connToken(user string, exp int64) string {
 	// NOTE that JWT must be generated on backend side of your application!
 	// Here we are generating it on client side only for example simplicity.
-	claims := jwt.MapClaims{"sub": user}
-	if exp > 0 {
-		claims["exp"] = exp
-	}
-	t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	t, err := jwt.ConnectionToken(jwt.ConnectionClaims{Sub: user, Exp: exp}, []byte("secret"))
 	if err != nil {
 		panic(err)
 	}
@@ -27,14 +23,7 @@ func connToken(user string, exp int64) string {
 func subscriptionToken(channel string, client string, exp int64) string {
 	// NOTE that JWT must be generated on backend side of your application!
 	// Here we are generating it on client side only for example simplicity.
-	claims := jwt.MapClaims{"channel": channel}
-	if client != "" {
-		claims["client"] = client
-	}
-	if exp > 0 {
-		claims["exp"] = exp
-	}
-	t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	t, err := jwt.SubscriptionToken(jwt.SubscriptionClaims{Channel: channel, Client: client, Exp: exp}, []byte("secret"))
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,115 @@
+package centrifuge
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TagsFilter is an expression over Publication tags. Leaf nodes compare tag
+// value, "and"/"or" nodes combine child nodes. Use TagsEq, TagsIn, TagsAnd and
+// TagsOr to build filters.
+type TagsFilter struct {
+	// Op is a logical operation of node: "and", "or" or empty for leaf node.
+	Op string
+	// Key of tag to compare in leaf node.
+	Key string
+	// Cmp is a comparison of leaf node: "eq" or "in".
+	Cmp string
+	// Val to compare tag value with for "eq".
+	Val string
+	// Vals to compare tag value with for "in".
+	Vals []string
+	// Nodes to combine for "and" and "or".
+	Nodes []*TagsFilter
+}
+
+// TagsEq matches publications with tag key equal to value.
+func TagsEq(key string, value string) *TagsFilter {
+	return &TagsFilter{Key: key, Cmp: "eq", Val: value}
+}
+
+// TagsIn matches publications with tag key equal to one of values.
+func TagsIn(key string, values ...string) *TagsFilter {
+	return &TagsFilter{Key: key, Cmp: "in", Vals: values}
+}
+
+// TagsAnd matches publications matching all filters.
+func TagsAnd(filters ...*TagsFilter) *TagsFilter {
+	return &TagsFilter{Op: "and", Nodes: filters}
+}
+
+// TagsOr matches publications matching any of filters.
+func TagsOr(filters ...*TagsFilter) *TagsFilter {
+	return &TagsFilter{Op: "or", Nodes: filters}
+}
+
+// Match reports whether tags match filter. Unknown operations and nil filter
+// nodes never match.
+func (f *TagsFilter) Match(tags map[string]string) bool {
+	if f == nil {
+		return false
+	}
+	switch f.Op {
+	case "and":
+		for _, n := range f.Nodes {
+			if !n.Match(tags) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, n := range f.Nodes {
+			if n.Match(tags) {
+				return true
+			}
+		}
+		return false
+	case "":
+		value, ok := tags[f.Key]
+		if !ok {
+			return false
+		}
+		switch f.Cmp {
+		case "eq":
+			return value == f.Val
+		case "in":
+			for _, v := range f.Vals {
+				if value == v {
+					return true
+				}
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// validate checks filter has only known operations and comparisons.
+func (f *TagsFilter) validate() error {
+	switch f.Op {
+	case "and", "or":
+		if len(f.Nodes) == 0 {
+			return fmt.Errorf("%q node without child nodes", f.Op)
+		}
+		for _, n := range f.Nodes {
+			if n == nil {
+				return fmt.Errorf("nil child node in %q node", f.Op)
+			}
+			if err := n.validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "":
+		if f.Key == "" {
+			return errors.New("leaf node without key")
+		}
+		if f.Cmp != "eq" && f.Cmp != "in" {
+			return fmt.Errorf("unknown comparison %q", f.Cmp)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", f.Op)
+	}
+}
@@ -0,0 +1,88 @@
+package centrifuge
+
+import "sync"
+
+// handlerEntry is a handler in handlerList. Handler set by On* methods has
+// zero id, handlers added by Add*Handler methods have unique positive ids.
+type handlerEntry struct {
+	id uint64
+	fn interface{}
+}
+
+// handlerList is an ordered list of handlers of one event.
+type handlerList struct {
+	entries []handlerEntry
+}
+
+// set replaces handler set by On* method keeping its position in list, or
+// appends it if not set yet.
+func (l *handlerList) set(fn interface{}) {
+	for i, e := range l.entries {
+		if e.id == 0 {
+			l.entries[i].fn = fn
+			return
+		}
+	}
+	l.entries = append(l.entries, handlerEntry{fn: fn})
+}
+
+func (l *handlerList) add(id uint64, fn interface{}) {
+	l.entries = append(l.entries, handlerEntry{id: id, fn: fn})
+}
+
+func (l *handlerList) remove(id uint64) {
+	for i, e := range l.entries {
+		if e.id == id {
+			l.entries = append(l.entries[:i:i], l.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// fns returns a copy of handlers in registration order so it can be used by
+// event dispatch while list is modified.
+func (l *handlerList) fns() []interface{} {
+	if len(l.entries) == 0 {
+		return nil
+	}
+	fns := make([]interface{}, 0, len(l.entries))
+	for _, e := range l.entries {
+		fns = append(fns, e.fn)
+	}
+	return fns
+}
+
+// handlerRegistry protects handler lists of event hub and rebuilds hub after
+// every change.
+type handlerRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+}
+
+func (r *handlerRegistry) set(l *handlerList, fn interface{}, rebuild func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l.set(fn)
+	rebuild()
+}
+
+// add appends handler to list and returns function to remove it. Returned
+// function is safe to call several times.
+func (r *handlerRegistry) add(l *handlerList, fn interface{}, rebuild func()) func() {
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	l.add(id, fn)
+	rebuild()
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			l.remove(id)
+			rebuild()
+		})
+	}
+}
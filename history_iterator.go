@@ -0,0 +1,131 @@
+package centrifuge
+
+import (
+	"context"
+)
+
+// defaultHistoryPageSize is a number of publications HistoryIterator requests
+// at once when WithHistoryLimit not set.
+const defaultHistoryPageSize = 100
+
+// HistoryIterator iterates over channel history issuing successive history
+// requests from the last seen offset. Use it like:
+//
+//	it := client.HistoryIterator(ctx, channel)
+//	for it.Next() {
+//		pub := it.Publication()
+//	}
+//	if err := it.Err(); err != nil {
+//		// Handle error.
+//	}
+//
+// Iteration stops with ErrHistoryEpochChanged if stream epoch changed between
+// requests since offsets of the new stream are not related to the old one.
+type HistoryIterator struct {
+	ctx      context.Context
+	history  func(ctx context.Context, opts ...HistoryOption) (HistoryResult, error)
+	pageSize int32
+	reverse  bool
+	since    *StreamPosition
+
+	pubs []Publication
+	idx  int
+	pub  Publication
+	done bool
+	err  error
+}
+
+// HistoryIterator returns iterator over channel history. WithHistoryLimit
+// sets page size (100 by default), WithHistorySince sets position to start
+// after, WithHistoryReverse makes iterator go from newest to oldest
+// publications.
+func (c *Client) HistoryIterator(ctx context.Context, channel string, opts ...HistoryOption) *HistoryIterator {
+	return newHistoryIterator(ctx, func(ctx context.Context, opts ...HistoryOption) (HistoryResult, error) {
+		return c.History(ctx, channel, opts...)
+	}, opts)
+}
+
+// HistoryIterator returns iterator over channel history. See
+// Client.HistoryIterator for details.
+func (s *Subscription) HistoryIterator(ctx context.Context, opts ...HistoryOption) *HistoryIterator {
+	return newHistoryIterator(ctx, s.History, opts)
+}
+
+func newHistoryIterator(ctx context.Context, history func(ctx context.Context, opts ...HistoryOption) (HistoryResult, error), opts []HistoryOption) *HistoryIterator {
+	historyOpts := &HistoryOptions{}
+	for _, opt := range opts {
+		opt(historyOpts)
+	}
+	pageSize := historyOpts.Limit
+	if pageSize <= 0 {
+		pageSize = defaultHistoryPageSize
+	}
+	return &HistoryIterator{
+		ctx:      ctx,
+		history:  history,
+		pageSize: pageSize,
+		reverse:  historyOpts.Reverse,
+		since:    historyOpts.Since,
+	}
+}
+
+// Next advances iterator to the next publication. It returns false when
+// there are no more publications or error happened.
+func (it *HistoryIterator) Next() bool {
+	for it.idx >= len(it.pubs) {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+	it.pub = it.pubs[it.idx]
+	it.idx++
+	return true
+}
+
+// Publication returns current publication.
+func (it *HistoryIterator) Publication() Publication {
+	return it.pub
+}
+
+// Err returns error which stopped iteration.
+func (it *HistoryIterator) Err() error {
+	return it.err
+}
+
+func (it *HistoryIterator) fetch() {
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return
+	}
+	opts := []HistoryOption{WithHistoryLimit(it.pageSize), WithHistoryReverse(it.reverse)}
+	if it.since != nil {
+		opts = append(opts, WithHistorySince(it.since))
+	}
+	res, err := it.history(it.ctx, opts...)
+	if err != nil {
+		it.err = err
+		return
+	}
+	if it.since != nil && it.since.Epoch != "" && res.Epoch != it.since.Epoch {
+		it.err = ErrHistoryEpochChanged
+		return
+	}
+	it.pubs = res.Publications
+	it.idx = 0
+	// Server may return less than limit publications even if stream has
+	// more, so completion decided by offsets only.
+	if len(res.Publications) == 0 {
+		it.done = true
+		return
+	}
+	last := res.Publications[len(res.Publications)-1].Offset
+	if (!it.reverse && last >= res.Offset) || (it.reverse && last <= 1) {
+		it.done = true
+	}
+	if it.since != nil && ((!it.reverse && last <= it.since.Offset) || (it.reverse && it.since.Offset > 0 && last >= it.since.Offset)) {
+		// No progress, asking again won't help.
+		it.done = true
+	}
+	it.since = &StreamPosition{Offset: last, Epoch: res.Epoch}
+}
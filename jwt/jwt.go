@@ -0,0 +1,121 @@
+// Package jwt helps with Centrifugo JWT: it creates HS256 connection and
+// subscription tokens and extracts token expiration time.
+//
+// Tokens should be generated on application backend side, creating them on
+// client side is only useful for examples, tests and trusted environments.
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrMalformedToken returned when token can't be parsed.
+var ErrMalformedToken = errors.New("malformed token")
+
+// ConnectionClaims are claims of Centrifugo connection token.
+type ConnectionClaims struct {
+	// Sub is a user ID. Empty for anonymous user.
+	Sub string `json:"sub"`
+	// Exp is a token expiration time as Unix timestamp in seconds. Zero
+	// value means token never expires.
+	Exp int64 `json:"exp,omitempty"`
+	// Info is an additional JSON information about connection.
+	Info json.RawMessage `json:"info,omitempty"`
+	// Base64Info is an additional binary information about connection encoded
+	// to base64.
+	Base64Info string `json:"b64info,omitempty"`
+	// Subs is a map of channels to subscribe connection to on server side.
+	Subs map[string]SubscribeOptions `json:"subs,omitempty"`
+	// Meta is a JSON information attached to connection which is not
+	// exposed to clients.
+	Meta json.RawMessage `json:"meta,omitempty"`
+}
+
+// SubscribeOptions of server-side subscription in ConnectionClaims.Subs.
+type SubscribeOptions struct {
+	// Info is an additional JSON information about channel subscription.
+	Info json.RawMessage `json:"info,omitempty"`
+	// Base64Info is an additional binary information about channel
+	// subscription encoded to base64.
+	Base64Info string `json:"b64info,omitempty"`
+	// Data is a JSON sent to client in subscribe result.
+	Data json.RawMessage `json:"data,omitempty"`
+	// Base64Data is a binary data sent to client in subscribe result encoded
+	// to base64.
+	Base64Data string `json:"b64data,omitempty"`
+}
+
+// SubscriptionClaims are claims of Centrifugo subscription token.
+type SubscriptionClaims struct {
+	// Sub is a user ID, must match user ID of connection.
+	Sub string `json:"sub,omitempty"`
+	// Channel to subscribe to.
+	Channel string `json:"channel"`
+	// Client is a client ID, used by legacy private channel tokens.
+	Client string `json:"client,omitempty"`
+	// Exp is a token expiration time as Unix timestamp in seconds. Zero
+	// value means token never expires.
+	Exp int64 `json:"exp,omitempty"`
+	// Info is an additional JSON information about channel subscription.
+	Info json.RawMessage `json:"info,omitempty"`
+	// Base64Info is an additional binary information about channel
+	// subscription encoded to base64.
+	Base64Info string `json:"b64info,omitempty"`
+}
+
+// ConnectionToken creates HS256 connection token signed with secret.
+func ConnectionToken(claims ConnectionClaims, secret []byte) (string, error) {
+	return sign(claims, secret)
+}
+
+// SubscriptionToken creates HS256 subscription token signed with secret.
+func SubscriptionToken(claims SubscriptionClaims, secret []byte) (string, error) {
+	return sign(claims, secret)
+}
+
+var encodedHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func sign(claims interface{}, secret []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ExpiresAt returns expiration time from exp claim of token without
+// verifying token signature. It returns zero time and false if token has no
+// exp claim.
+func ExpiresAt(token string) (time.Time, bool, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false, ErrMalformedToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false, ErrMalformedToken
+	}
+	var claims struct {
+		Exp *json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, false, ErrMalformedToken
+	}
+	if claims.Exp == nil {
+		return time.Time{}, false, nil
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil {
+		return time.Time{}, false, ErrMalformedToken
+	}
+	return time.Unix(int64(exp), 0), true, nil
+}
@@ -0,0 +1,58 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionToken(t *testing.T) {
+	secret := []byte("secret")
+	token, err := ConnectionToken(ConnectionClaims{
+		Sub:  "42",
+		Exp:  1700000000,
+		Info: json.RawMessage(`{"name":"alex"}`),
+		Subs: map[string]SubscribeOptions{"news": {}},
+	}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("unexpected token %s", token)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) != parts[2] {
+		t.Fatal("wrong signature")
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	expected := `{"sub":"42","exp":1700000000,"info":{"name":"alex"},"subs":{"news":{}}}`
+	if string(payload) != expected {
+		t.Fatalf("expected payload %s, got %s", expected, payload)
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	token, err := SubscriptionToken(SubscriptionClaims{Channel: "$news", Exp: 1700000000}, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, ok, err := ExpiresAt(token)
+	if err != nil || !ok || !exp.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected expiration %v %v %v", exp, ok, err)
+	}
+
+	token, _ = SubscriptionToken(SubscriptionClaims{Channel: "$news"}, []byte("secret"))
+	if _, ok, err := ExpiresAt(token); err != nil || ok {
+		t.Fatalf("expected no expiration, got %v %v", ok, err)
+	}
+
+	if _, _, err := ExpiresAt("not a token"); err != ErrMalformedToken {
+		t.Fatalf("expected ErrMalformedToken, got %v", err)
+	}
+}
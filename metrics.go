@@ -0,0 +1,56 @@
+package centrifuge
+
+// Logger receives Client log messages. *log.Logger from standard library
+// implements it.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Metrics allows collecting Client metrics, e.g. exporting them to
+// Prometheus. Methods are called synchronously from Client internals, so
+// they must be fast and safe for concurrent use. Error is called without
+// Client locks held, so it may call Client methods.
+type Metrics interface {
+	// ConnectAttempt called before every connection attempt.
+	ConnectAttempt()
+	// Connected called when Client connected.
+	Connected()
+	// Disconnected called when connection lost or closed with disconnect
+	// code.
+	Disconnected(code uint32)
+	// Error called for every error passed to OnError handler.
+	Error(err error)
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.config.Logger != nil {
+		c.config.Logger.Printf(format, args...)
+	}
+}
+
+func (c *Client) observeConnectAttempt() {
+	if c.config.Metrics != nil {
+		c.config.Metrics.ConnectAttempt()
+	}
+}
+
+func (c *Client) observeConnected() {
+	c.logf("centrifuge: connected")
+	if c.config.Metrics != nil {
+		c.config.Metrics.Connected()
+	}
+}
+
+func (c *Client) observeDisconnected(code uint32, reason string) {
+	c.logf("centrifuge: disconnected, code %d, reason %s", code, reason)
+	if c.config.Metrics != nil {
+		c.config.Metrics.Disconnected(code)
+	}
+}
+
+func (c *Client) observeError(err error) {
+	c.logf("centrifuge: %v", err)
+	if c.config.Metrics != nil {
+		c.config.Metrics.Error(err)
+	}
+}
@@ -0,0 +1,76 @@
+package centrifuge
+
+import (
+	"fmt"
+
+	"github.com/centrifugal/protocol"
+)
+
+// Option configures Client created with NewClient. Options are applied on top
+// of Config passed to NewClient.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	protocolType protocol.Type
+	config       Config
+}
+
+// WithProtocolType sets protocol Client uses: protocol.TypeJSON (default) or
+// protocol.TypeProtobuf.
+func WithProtocolType(protocolType protocol.Type) Option {
+	return func(o *clientOptions) {
+		o.protocolType = protocolType
+	}
+}
+
+// WithTransports sets Config.Transports.
+func WithTransports(transports ...TransportEndpoint) Option {
+	return func(o *clientOptions) {
+		o.config.Transports = transports
+	}
+}
+
+// WithReconnectStrategy sets Config.ReconnectStrategy.
+func WithReconnectStrategy(strategy ReconnectStrategy) Option {
+	return func(o *clientOptions) {
+		o.config.ReconnectStrategy = strategy
+	}
+}
+
+// WithLogger sets Config.Logger.
+func WithLogger(logger Logger) Option {
+	return func(o *clientOptions) {
+		o.config.Logger = logger
+	}
+}
+
+// WithMetrics sets Config.Metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *clientOptions) {
+		o.config.Metrics = metrics
+	}
+}
+
+// NewClient initializes Client. Unlike NewJsonClient and NewProtobufClient it
+// returns ConfigError for malformed endpoint or invalid config instead of
+// returning it later from Client.Connect.
+// After client initialized call Client.Connect method.
+// Use Client.NewSubscription to create Subscription objects.
+func NewClient(endpoint string, config Config, opts ...Option) (*Client, error) {
+	o := &clientOptions{
+		protocolType: protocol.TypeJSON,
+		config:       config,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.protocolType != protocol.TypeJSON && o.protocolType != protocol.TypeProtobuf {
+		return nil, ConfigError{Field: "protocol", Reason: fmt.Sprintf("unsupported protocol type: %s", o.protocolType)}
+	}
+	client, err := newClient(endpoint, o.protocolType, o.config)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,218 @@
+package centrifuge
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PositionStore persists stream positions of recoverable subscriptions, so
+// Client can recover missed publications after process restart. Client saves
+// position after each publication, loads it when subscribing without a
+// position in memory and passes positions of channels not known to Client
+// to a server in connect request to recover server-side subscriptions.
+// Implementations must be safe for concurrent use.
+type PositionStore interface {
+	// Load returns saved stream position of channel. Ok is false if there is
+	// no position saved for channel.
+	Load(channel string) (pos StreamPosition, ok bool, err error)
+	// Save stores stream position of channel.
+	Save(channel string, pos StreamPosition) error
+	// Channels returns all channels with saved position.
+	Channels() ([]string, error)
+}
+
+// MemoryPositionStore is a PositionStore which keeps positions in memory. It
+// allows sharing positions between Client instances inside one process.
+type MemoryPositionStore struct {
+	mu        sync.RWMutex
+	positions map[string]StreamPosition
+}
+
+// NewMemoryPositionStore creates MemoryPositionStore.
+func NewMemoryPositionStore() *MemoryPositionStore {
+	return &MemoryPositionStore{
+		positions: make(map[string]StreamPosition),
+	}
+}
+
+// Load implements PositionStore.
+func (s *MemoryPositionStore) Load(channel string) (StreamPosition, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pos, ok := s.positions[channel]
+	return pos, ok, nil
+}
+
+// Save implements PositionStore.
+func (s *MemoryPositionStore) Save(channel string, pos StreamPosition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.positions[channel] = pos
+	return nil
+}
+
+// Channels implements PositionStore.
+func (s *MemoryPositionStore) Channels() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedChannels(s.positions), nil
+}
+
+// filePositionFlushInterval is how long FilePositionStore waits after Save
+// before writing positions to file, so positions of publications coming in
+// bursts are written at once.
+const filePositionFlushInterval = time.Second
+
+// FilePositionStore is a PositionStore which keeps positions in a JSON file.
+// Save only updates positions in memory, changed positions are written to file
+// atomically in background within a second after Save. Call Flush to write
+// them right away, Client calls it on Close.
+type FilePositionStore struct {
+	mu        sync.Mutex
+	path      string
+	positions map[string]StreamPosition
+	dirty     bool
+	timer     *time.Timer
+	flushErr  error
+	// writeMu serializes file writes, so file is never replaced with older
+	// positions.
+	writeMu sync.Mutex
+}
+
+type filePosition struct {
+	Offset uint64 `json:"offset"`
+	Epoch  string `json:"epoch"`
+}
+
+// NewFilePositionStore creates FilePositionStore loading positions from file
+// at path if it exists.
+func NewFilePositionStore(path string) (*FilePositionStore, error) {
+	s := &FilePositionStore{
+		path:      path,
+		positions: make(map[string]StreamPosition),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+	var positions map[string]filePosition
+	if err := json.Unmarshal(data, &positions); err != nil {
+		return nil, err
+	}
+	for ch, pos := range positions {
+		s.positions[ch] = StreamPosition{Offset: pos.Offset, Epoch: pos.Epoch}
+	}
+	return s, nil
+}
+
+// Load implements PositionStore.
+func (s *FilePositionStore) Load(channel string) (StreamPosition, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pos, ok := s.positions[channel]
+	return pos, ok, nil
+}
+
+// Save implements PositionStore. It returns error of the previous background
+// write to file if any.
+func (s *FilePositionStore) Save(channel string, pos StreamPosition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flushErr
+	s.flushErr = nil
+	if prev, ok := s.positions[channel]; ok && prev == pos {
+		return err
+	}
+	s.positions[channel] = pos
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(filePositionFlushInterval, func() {
+			if err := s.Flush(); err != nil {
+				s.mu.Lock()
+				s.flushErr = err
+				s.mu.Unlock()
+			}
+		})
+	}
+	return err
+}
+
+// Flush writes changed positions to file.
+func (s *FilePositionStore) Flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	positions := make(map[string]filePosition, len(s.positions))
+	for ch, pos := range s.positions {
+		positions[ch] = filePosition{Offset: pos.Offset, Epoch: pos.Epoch}
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	err := writeFileAtomic(s.path, positions)
+	if err != nil {
+		s.mu.Lock()
+		// Write again on next Flush.
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// writeFileAtomic writes positions to temporary file synced to disk and
+// renames it to path, so file contains either old or new positions after a
+// crash.
+func writeFileAtomic(path string, positions map[string]filePosition) error {
+	data, err := json.Marshal(positions)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Channels implements PositionStore.
+func (s *FilePositionStore) Channels() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedChannels(s.positions), nil
+}
+
+func sortedChannels(positions map[string]StreamPosition) []string {
+	channels := make([]string, 0, len(positions))
+	for ch := range positions {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	return channels
+}
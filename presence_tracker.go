@@ -0,0 +1,191 @@
+package centrifuge
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// PresenceRoster is a snapshot of channel presence maintained by Subscription
+// with SubscriptionConfig.TrackPresence on.
+type PresenceRoster struct {
+	// Clients keyed by client ID.
+	Clients map[string]ClientInfo
+	// Users aggregates Clients by user ID, one user may have several
+	// connections. Connections of a user are sorted by client ID.
+	Users map[string][]ClientInfo
+}
+
+type presenceChange struct {
+	info  ClientInfo
+	leave bool
+}
+
+// presenceTracker keeps channel presence up to date applying join and leave
+// messages to presence snapshot. Subscription lock must be held when
+// accessing it.
+type presenceTracker struct {
+	clients map[string]ClientInfo
+	// syncing is true while presence snapshot is loading, changes are
+	// collected into pending and applied on top of snapshot.
+	syncing bool
+	syncID  uint64
+	pending []presenceChange
+	// syncAttempts is a number of failed attempts to load snapshot in a row.
+	syncAttempts int
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		clients: make(map[string]ClientInfo),
+	}
+}
+
+func (t *presenceTracker) startSync() uint64 {
+	t.syncing = true
+	t.syncID++
+	t.pending = nil
+	return t.syncID
+}
+
+func (t *presenceTracker) reset() {
+	t.clients = make(map[string]ClientInfo)
+	t.syncing = false
+	t.syncID++
+	t.pending = nil
+	t.syncAttempts = 0
+}
+
+// apply returns true if roster changed.
+func (t *presenceTracker) apply(change presenceChange) bool {
+	if t.syncing {
+		t.pending = append(t.pending, change)
+		return false
+	}
+	if change.leave {
+		if _, ok := t.clients[change.info.Client]; !ok {
+			return false
+		}
+		delete(t.clients, change.info.Client)
+		return true
+	}
+	t.clients[change.info.Client] = change.info
+	return true
+}
+
+func (t *presenceTracker) roster() PresenceRoster {
+	roster := PresenceRoster{
+		Clients: make(map[string]ClientInfo, len(t.clients)),
+		Users:   make(map[string][]ClientInfo),
+	}
+	for id, info := range t.clients {
+		roster.Clients[id] = info
+		roster.Users[info.User] = append(roster.Users[info.User], info)
+	}
+	for _, infos := range roster.Users {
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Client < infos[j].Client
+		})
+	}
+	return roster
+}
+
+// PresenceRoster returns current channel presence. It's only maintained when
+// SubscriptionConfig.TrackPresence is on, otherwise empty roster returned.
+func (s *Subscription) PresenceRoster() PresenceRoster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.presenceTracker == nil {
+		return newPresenceTracker().roster()
+	}
+	return s.presenceTracker.roster()
+}
+
+// syncPresence loads presence snapshot and applies changes received while
+// loading on top of it. When loading fails roster is cleared, as it's unknown
+// which changes were missed, and loading is retried with resubscribe backoff.
+func (s *Subscription) syncPresence(id uint64) {
+	res, err := s.Presence(context.Background())
+	s.mu.Lock()
+	if s.presenceTracker.syncID != id || s.state != SubStateSubscribed {
+		s.mu.Unlock()
+		return
+	}
+	t := s.presenceTracker
+	if err != nil {
+		t.clients = make(map[string]ClientInfo)
+		t.syncAttempts++
+		delay, retry := s.resubscribeStrategy.TimeBeforeNextAttempt(t.syncAttempts)
+		if retry {
+			// Changes are still collected, they are applied after
+			// snapshot loaded.
+			time.AfterFunc(delay, func() {
+				s.retryPresenceSync(id)
+			})
+			roster := t.roster()
+			s.mu.Unlock()
+			s.emitError(SubscriptionPresenceError{Err: err})
+			s.emitPresenceChanged(roster)
+			return
+		}
+	} else {
+		t.syncAttempts = 0
+		t.clients = make(map[string]ClientInfo, len(res.Clients))
+		for id, info := range res.Clients {
+			t.clients[id] = info
+		}
+	}
+	t.syncing = false
+	pending := t.pending
+	t.pending = nil
+	for _, change := range pending {
+		t.apply(change)
+	}
+	roster := t.roster()
+	s.mu.Unlock()
+
+	if err != nil {
+		s.emitError(SubscriptionPresenceError{Err: err})
+	}
+	s.emitPresenceChanged(roster)
+}
+
+// retryPresenceSync loads presence snapshot again after failed attempt id
+// unless subscription state changed meanwhile.
+func (s *Subscription) retryPresenceSync(id uint64) {
+	s.mu.Lock()
+	if s.presenceTracker.syncID != id || s.state != SubStateSubscribed {
+		s.mu.Unlock()
+		return
+	}
+	// Snapshot loaded from now on includes changes collected so far.
+	newID := s.presenceTracker.startSync()
+	s.mu.Unlock()
+	s.syncPresence(newID)
+}
+
+func (s *Subscription) trackPresence(change presenceChange) {
+	s.mu.Lock()
+	if s.state != SubStateSubscribed {
+		s.mu.Unlock()
+		return
+	}
+	changed := s.presenceTracker.apply(change)
+	var roster PresenceRoster
+	if changed {
+		roster = s.presenceTracker.roster()
+	}
+	s.mu.Unlock()
+	if changed {
+		s.emitPresenceChanged(roster)
+	}
+}
+
+func (s *Subscription) emitPresenceChanged(roster PresenceRoster) {
+	if events := s.events.load(); events.onPresenceChanged != nil {
+		handler := events.onPresenceChanged
+		s.centrifuge.runHandlerSync(func() {
+			handler(PresenceChangedEvent{PresenceRoster: roster})
+		})
+	}
+}
@@ -11,10 +11,11 @@ import (
 // https://github.com/nats-io/nats.go client released under Apache 2.0
 // license: see https://github.com/nats-io/nats.go/blob/master/LICENSE.
 type cbQueue struct {
-	mu   sync.Mutex
-	cond *sync.Cond
-	head *asyncCB
-	tail *asyncCB
+	mu     sync.Mutex
+	cond   *sync.Cond
+	head   *asyncCB
+	tail   *asyncCB
+	closed bool
 }
 
 type asyncCB struct {
@@ -50,9 +51,9 @@ func (q *cbQueue) dispatch() {
 }
 
 // Push adds the given function to the tail of the list and
-// signals the dispatcher.
-func (q *cbQueue) push(f func(duration time.Duration)) {
-	q.pushOrClose(f, false)
+// signals the dispatcher. It returns false if queue is already closed.
+func (q *cbQueue) push(f func(duration time.Duration)) bool {
+	return q.pushOrClose(f, false)
 }
 
 // Close signals that async queue must be closed.
@@ -60,7 +61,7 @@ func (q *cbQueue) close() {
 	q.pushOrClose(nil, true)
 }
 
-func (q *cbQueue) pushOrClose(f func(time.Duration), close bool) {
+func (q *cbQueue) pushOrClose(f func(time.Duration), close bool) bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	// Make sure that library is not calling push with nil function,
@@ -68,6 +69,11 @@ func (q *cbQueue) pushOrClose(f func(time.Duration), close bool) {
 	if !close && f == nil {
 		panic("pushing a nil callback with false close")
 	}
+	if q.closed {
+		// Dispatcher stopped, callbacks won't be called anymore.
+		return false
+	}
+	q.closed = close
 	cb := &asyncCB{fn: f, tm: time.Now()}
 	if q.tail != nil {
 		q.tail.next = cb
@@ -80,4 +86,5 @@ func (q *cbQueue) pushOrClose(f func(time.Duration), close bool) {
 	} else {
 		q.cond.Signal()
 	}
+	return true
 }
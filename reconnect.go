@@ -1,39 +1,160 @@
 package centrifuge
 
 import (
+	"math"
+	"math/rand"
 	"time"
 
 	"github.com/jpillora/backoff"
 )
 
-type reconnectStrategy interface {
-	timeBeforeNextAttempt(attempt int) time.Duration
+// ReconnectStrategy allows customizing delays between reconnect attempts of
+// Client and resubscribe attempts of Subscription.
+type ReconnectStrategy interface {
+	// TimeBeforeNextAttempt returns a delay before the next attempt. Attempt
+	// is a number of failed attempts since last successful connect (or
+	// subscribe), starting with 1. Returning false stops further attempts:
+	// Client moves to disconnected state, Subscription moves to unsubscribed
+	// state.
+	TimeBeforeNextAttempt(attempt int) (time.Duration, bool)
 }
 
-type backoffReconnect struct {
+// BackoffReconnect is an exponential backoff ReconnectStrategy. This is a
+// strategy Client and Subscription use by default.
+type BackoffReconnect struct {
 	// Factor is the multiplying factor for each increment step.
 	Factor float64
 	// Jitter eases contention by randomizing backoff steps.
 	Jitter bool
-	// MinMilliseconds is a minimum value of reconnect interval.
+	// MinDelay is a minimum value of reconnect interval.
 	MinDelay time.Duration
-	// MaxMilliseconds is a maximum value of reconnect interval.
+	// MaxDelay is a maximum value of reconnect interval.
 	MaxDelay time.Duration
 }
 
-var defaultBackoffReconnect = &backoffReconnect{
+var defaultBackoffReconnect = &BackoffReconnect{
 	MinDelay: 200 * time.Millisecond,
 	MaxDelay: 20 * time.Second,
 	Factor:   2,
 	Jitter:   true,
 }
 
-func (r *backoffReconnect) timeBeforeNextAttempt(attempt int) time.Duration {
+// TimeBeforeNextAttempt implements ReconnectStrategy.
+func (r *BackoffReconnect) TimeBeforeNextAttempt(attempt int) (time.Duration, bool) {
 	b := &backoff.Backoff{
 		Min:    r.MinDelay,
 		Max:    r.MaxDelay,
 		Factor: r.Factor,
 		Jitter: r.Jitter,
 	}
-	return b.ForAttempt(float64(attempt))
+	return b.ForAttempt(float64(attempt)), true
+}
+
+// FullJitterReconnect is an exponential backoff ReconnectStrategy which picks a
+// random delay between zero and exponentially growing value. It spreads
+// reconnects of many clients most evenly.
+type FullJitterReconnect struct {
+	// Factor is the multiplying factor for each increment step.
+	// Zero value means 2.
+	Factor float64
+	// MinDelay is a base value of exponential growth.
+	// Zero value means 200 * time.Millisecond.
+	MinDelay time.Duration
+	// MaxDelay is a maximum value of reconnect interval.
+	// Zero value means 20 * time.Second.
+	MaxDelay time.Duration
+}
+
+// TimeBeforeNextAttempt implements ReconnectStrategy.
+func (r *FullJitterReconnect) TimeBeforeNextAttempt(attempt int) (time.Duration, bool) {
+	factor := r.Factor
+	if factor == 0 {
+		factor = 2
+	}
+	minDelay, maxDelay := jitterDelayBounds(r.MinDelay, r.MaxDelay)
+	ceil := float64(minDelay) * math.Pow(factor, float64(attempt))
+	if ceil > float64(maxDelay) || math.IsInf(ceil, 0) {
+		ceil = float64(maxDelay)
+	}
+	return time.Duration(rand.Int63n(int64(ceil) + 1)), true
+}
+
+// DecorrelatedJitterReconnect is a ReconnectStrategy where each delay is a
+// random value between MinDelay and three times the previous delay. Unlike
+// FullJitterReconnect it never goes below MinDelay.
+type DecorrelatedJitterReconnect struct {
+	// MinDelay is a minimum value of reconnect interval.
+	// Zero value means 200 * time.Millisecond.
+	MinDelay time.Duration
+	// MaxDelay is a maximum value of reconnect interval.
+	// Zero value means 20 * time.Second.
+	MaxDelay time.Duration
+}
+
+// TimeBeforeNextAttempt implements ReconnectStrategy.
+func (r *DecorrelatedJitterReconnect) TimeBeforeNextAttempt(attempt int) (time.Duration, bool) {
+	minDelay, maxDelay := jitterDelayBounds(r.MinDelay, r.MaxDelay)
+	// Strategy is stateless, so we replay delay sequence to keep it safe to
+	// share between many Subscriptions.
+	delay := minDelay
+	for i := 0; i < attempt; i++ {
+		upper := 3 * delay
+		if upper <= minDelay {
+			delay = minDelay
+		} else {
+			delay = minDelay + time.Duration(rand.Int63n(int64(upper-minDelay)+1))
+		}
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	return delay, true
+}
+
+// jitterDelayBounds returns delay bounds of jitter strategies. Zero values
+// mean bounds of default BackoffReconnect, as zero delays would make clients
+// reconnect without any pause.
+func jitterDelayBounds(minDelay, maxDelay time.Duration) (time.Duration, time.Duration) {
+	if minDelay <= 0 {
+		minDelay = defaultBackoffReconnect.MinDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultBackoffReconnect.MaxDelay
+	}
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	return minDelay, maxDelay
+}
+
+// ConstantReconnect is a ReconnectStrategy with the same delay between all
+// attempts.
+type ConstantReconnect struct {
+	// Delay between attempts.
+	Delay time.Duration
+}
+
+// TimeBeforeNextAttempt implements ReconnectStrategy.
+func (r *ConstantReconnect) TimeBeforeNextAttempt(_ int) (time.Duration, bool) {
+	return r.Delay, true
+}
+
+// CappedReconnect wraps ReconnectStrategy to stop after MaxAttempts attempts.
+type CappedReconnect struct {
+	// Strategy to get delays from. Zero value means default BackoffReconnect.
+	Strategy ReconnectStrategy
+	// MaxAttempts is a maximum number of attempts.
+	MaxAttempts int
+}
+
+// TimeBeforeNextAttempt implements ReconnectStrategy.
+func (r *CappedReconnect) TimeBeforeNextAttempt(attempt int) (time.Duration, bool) {
+	if attempt > r.MaxAttempts {
+		return 0, false
+	}
+	strategy := r.Strategy
+	if strategy == nil {
+		strategy = defaultBackoffReconnect
+	}
+	return strategy.TimeBeforeNextAttempt(attempt)
 }
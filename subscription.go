@@ -3,6 +3,7 @@ package centrifuge
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -32,10 +33,64 @@ type SubscriptionConfig struct {
 	// in channels with history stream on.
 	Positioned bool
 	// Recoverable flag asks server to make Subscription recoverable. Only makes sense
-	// in channels with history stream on.
+	// in channels with history stream on. Requires Positioned as recovery is
+	// based on stream position.
 	Recoverable bool
 	// JoinLeave flag asks server to push join/leave messages.
 	JoinLeave bool
+	// ResubscribeStrategy allows customizing delays between resubscribe attempts.
+	// Zero value means the same BackoffReconnect Client uses by default.
+	ResubscribeStrategy ReconnectStrategy
+	// TagsFilter allows receiving only publications with matching tags.
+	// Protocol version this client uses can't pass filter to a server yet, so
+	// all publications are still delivered over network and filter is applied
+	// on client side.
+	TagsFilter *TagsFilter
+	// Since allows starting Subscription with recovery from a known stream
+	// position, e.g. one saved from Subscription.StreamPosition earlier. Only
+	// makes sense in channels with history stream and recovery on.
+	Since *StreamPosition
+	// HistoryCatchUp turns on loading missed publications from channel history
+	// when recovery failed (SubscribedEvent has WasRecovering true and Recovered
+	// false) but stream epoch is still the same. Missed publications are
+	// delivered to OnPublication handler in order, live publications received
+	// meanwhile are buffered and delivered after them.
+	HistoryCatchUp bool
+	// ResubscribeOnGap makes Subscription resubscribe with recovery when a gap
+	// in publication offsets of positioned or recoverable stream detected.
+	// Gap event is emitted if missed publications were not recovered.
+	// By default, gap event is emitted and publication is delivered.
+	// Duplicate publications are always dropped.
+	ResubscribeOnGap bool
+	// TrackPresence turns on maintaining live channel presence: Subscription
+	// loads presence after every subscribe and applies join/leave messages to
+	// it. See Subscription.PresenceRoster and Subscription.OnPresenceChanged.
+	// Requires JoinLeave to be on and presence enabled for channel on server.
+	TrackPresence bool
+}
+
+// Validate checks SubscriptionConfig for invalid and contradictory settings.
+// It returns ConfigError describing the first problem found.
+// Client.NewSubscription calls Validate and returns its error.
+//
+// Recoverable and Positioned are not checked against other options as server
+// may force positioning and recovery for channel namespace.
+func (c SubscriptionConfig) Validate() error {
+	if c.Recoverable && !c.Positioned {
+		return ConfigError{Field: "Recoverable", Reason: "requires Positioned"}
+	}
+	if c.Since != nil && c.Since.Epoch == "" {
+		return ConfigError{Field: "Since", Reason: "stream position epoch required"}
+	}
+	if c.TrackPresence && !c.JoinLeave {
+		return ConfigError{Field: "TrackPresence", Reason: "requires JoinLeave"}
+	}
+	if c.TagsFilter != nil {
+		if err := c.TagsFilter.validate(); err != nil {
+			return ConfigError{Field: "TagsFilter", Reason: err.Error()}
+		}
+	}
+	return nil
 }
 
 func newSubscription(c *Client, channel string, config ...SubscriptionConfig) *Subscription {
@@ -50,10 +105,28 @@ func newSubscription(c *Client, channel string, config ...SubscriptionConfig) *S
 	if len(config) == 1 {
 		cfg := config[0]
 		s.token = cfg.Token
+		s.getToken = cfg.GetToken
+		if cfg.Token != "" {
+			c.subTokens.set(channel, cfg.Token, 0)
+		}
 		s.data = cfg.Data
 		s.positioned = cfg.Positioned
 		s.recoverable = cfg.Recoverable
 		s.joinLeave = cfg.JoinLeave
+		if cfg.ResubscribeStrategy != nil {
+			s.resubscribeStrategy = cfg.ResubscribeStrategy
+		}
+		s.tagsFilter = cfg.TagsFilter
+		s.historyCatchUp = cfg.HistoryCatchUp
+		s.resubscribeOnGap = cfg.ResubscribeOnGap
+		if cfg.TrackPresence {
+			s.presenceTracker = newPresenceTracker()
+		}
+		if cfg.Since != nil {
+			s.recover = true
+			s.offset = cfg.Since.Offset
+			s.epoch = cfg.Since.Epoch
+		}
 	}
 	return s
 }
@@ -80,12 +153,29 @@ type Subscription struct {
 	positioned  bool
 	recoverable bool
 	joinLeave   bool
+	tagsFilter  *TagsFilter
+
+	historyCatchUp   bool
+	resubscribeOnGap bool
+	// streamPositioned is true when server made Subscription positioned or
+	// recoverable, so publication offsets must be sequential.
+	streamPositioned bool
+
+	presenceTracker *presenceTracker
+	// recoverFrom is a position sent in the last subscribe request.
+	recoverFrom *StreamPosition
+	// gapRecoverFrom is a position to recover from after publication gap,
+	// recovery is requested even if subscription is not recoverable.
+	gapRecoverFrom *StreamPosition
+	catchingUp     bool
+	catchUpID      uint64
+	catchUpBuf     []*protocol.Publication
 
 	token    string
 	getToken func(SubscriptionTokenEvent) (string, error)
 
 	resubscribeAttempts int
-	resubscribeStrategy reconnectStrategy
+	resubscribeStrategy ReconnectStrategy
 
 	resubscribeTimer *time.Timer
 	refreshTimer     *time.Timer
@@ -106,6 +196,19 @@ func newSubFuture(fn func(error)) subFuture {
 	return subFuture{fn: fn, closeCh: make(chan struct{})}
 }
 
+// StreamPosition returns current position of Subscription in channel stream:
+// the one received on subscribe and updated with every publication. It
+// returns nil if position is not known – i.e. Subscription was never
+// subscribed to a positioned or recoverable channel.
+func (s *Subscription) StreamPosition() *StreamPosition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.epoch == "" {
+		return nil
+	}
+	return &StreamPosition{Offset: s.offset, Epoch: s.epoch}
+}
+
 func (s *Subscription) nextFutureID() uint64 {
 	return atomic.AddUint64(&s.futureID, 1)
 }
@@ -349,7 +452,7 @@ func (s *Subscription) unsubscribe(code uint32, reason string, sendUnsubscribe b
 	if sendUnsubscribe {
 		s.centrifuge.unsubscribe(s.Channel, func(result UnsubscribeResult, err error) {
 			if err != nil {
-				go s.centrifuge.handleDisconnect(&disconnect{Code: connectingUnsubscribeError, Reason: "unsubscribe error", Reconnect: true})
+				go s.centrifuge.handleDisconnect(&Disconnect{Code: connectingUnsubscribeError, Reason: "unsubscribe error", Reconnect: true})
 				return
 			}
 		})
@@ -369,8 +472,8 @@ func (s *Subscription) Subscribe() error {
 	s.state = SubStateSubscribing
 	s.mu.Unlock()
 
-	if s.events != nil && s.events.onSubscribing != nil {
-		handler := s.events.onSubscribing
+	if events := s.events.load(); events.onSubscribing != nil {
+		handler := events.onSubscribing
 		s.centrifuge.runHandlerAsync(func() {
 			handler(SubscribingEvent{
 				Code:   subscribingSubscribeCalled,
@@ -388,7 +491,13 @@ func (s *Subscription) Subscribe() error {
 
 func (s *Subscription) moveToUnsubscribed(code uint32, reason string) {
 	s.mu.Lock()
+	if s.presenceTracker != nil {
+		s.presenceTracker.reset()
+	}
 	s.resubscribeAttempts = 0
+	s.catchingUp = false
+	s.catchUpBuf = nil
+	s.gapRecoverFrom = nil
 	if s.resubscribeTimer != nil {
 		s.resubscribeTimer.Stop()
 	}
@@ -400,8 +509,8 @@ func (s *Subscription) moveToUnsubscribed(code uint32, reason string) {
 	s.state = SubStateUnsubscribed
 	s.mu.Unlock()
 
-	if needEvent && s.events != nil && s.events.onUnsubscribe != nil {
-		handler := s.events.onUnsubscribe
+	if events := s.events.load(); needEvent && events.onUnsubscribe != nil {
+		handler := events.onUnsubscribe
 		s.centrifuge.runHandlerAsync(func() {
 			handler(UnsubscribedEvent{
 				Code:   code,
@@ -413,7 +522,12 @@ func (s *Subscription) moveToUnsubscribed(code uint32, reason string) {
 
 func (s *Subscription) moveToSubscribing(code uint32, reason string) {
 	s.mu.Lock()
+	if s.presenceTracker != nil {
+		s.presenceTracker.reset()
+	}
 	s.resubscribeAttempts = 0
+	s.catchingUp = false
+	s.catchUpBuf = nil
 	if s.resubscribeTimer != nil {
 		s.resubscribeTimer.Stop()
 	}
@@ -424,8 +538,8 @@ func (s *Subscription) moveToSubscribing(code uint32, reason string) {
 	s.state = SubStateSubscribing
 	s.mu.Unlock()
 
-	if needEvent && s.events != nil && s.events.onSubscribing != nil {
-		handler := s.events.onSubscribing
+	if events := s.events.load(); needEvent && events.onSubscribing != nil {
+		handler := events.onSubscribing
 		s.centrifuge.runHandlerAsync(func() {
 			handler(SubscribingEvent{
 				Code:   code,
@@ -443,11 +557,16 @@ func (s *Subscription) moveToSubscribed(res *protocol.SubscribeResult) {
 	}
 	s.state = SubStateSubscribed
 	if res.Expires {
-		s.scheduleSubRefresh(res.Ttl)
+		delay := s.centrifuge.refreshDelay(res.Ttl)
+		if s.token != "" {
+			s.centrifuge.subTokens.set(s.Channel, s.token, delay)
+		}
+		s.scheduleSubRefresh(delay)
 	}
 	if res.Recoverable {
 		s.recover = true
 	}
+	s.streamPositioned = res.Positioned || res.Recoverable
 	s.resubscribeAttempts = 0
 	if s.resubscribeTimer != nil {
 		s.resubscribeTimer.Stop()
@@ -455,10 +574,41 @@ func (s *Subscription) moveToSubscribed(res *protocol.SubscribeResult) {
 	s.resolveSubFutures(nil)
 	s.offset = res.Offset
 	s.epoch = res.Epoch
+	from := s.recoverFrom
+	s.recoverFrom = nil
+	startCatchUp := s.historyCatchUp && res.WasRecovering && !res.Recovered &&
+		from != nil && from.Epoch == res.Epoch && from.Offset < res.Offset
+	var gap *GapEvent
+	if s.gapRecoverFrom != nil {
+		if !res.Recovered && !startCatchUp && from != nil && from.Epoch == res.Epoch && from.Offset < res.Offset {
+			gap = &GapEvent{FromOffset: from.Offset + 1, ToOffset: res.Offset}
+		}
+		s.gapRecoverFrom = nil
+	}
+	var catchUpID uint64
+	if startCatchUp {
+		// Position moves forward while missed publications delivered.
+		s.offset = from.Offset
+		s.catchingUp = true
+		s.catchUpID++
+		catchUpID = s.catchUpID
+	}
+	var presenceSyncID uint64
+	if s.presenceTracker != nil {
+		presenceSyncID = s.presenceTracker.startSync()
+	}
 	s.mu.Unlock()
 
-	if s.events != nil && s.events.onSubscribed != nil {
-		handler := s.events.onSubscribed
+	if s.presenceTracker != nil {
+		go s.syncPresence(presenceSyncID)
+	}
+
+	if res.Recoverable && len(res.Publications) == 0 {
+		s.centrifuge.savePosition(s.Channel, StreamPosition{Offset: res.Offset, Epoch: res.Epoch})
+	}
+
+	if events := s.events.load(); events.onSubscribed != nil {
+		handler := events.onSubscribed
 		ev := SubscribedEvent{
 			Data:          res.GetData(),
 			Recovered:     res.GetRecovered(),
@@ -477,36 +627,43 @@ func (s *Subscription) moveToSubscribed(res *protocol.SubscribeResult) {
 		})
 	}
 
-	if len(res.Publications) > 0 {
-		s.centrifuge.runHandlerSync(func() {
-			pubs := res.Publications
-			for i := 0; i < len(pubs); i++ {
-				pub := res.Publications[i]
-				s.mu.Lock()
-				if s.state != SubStateSubscribed {
-					s.mu.Unlock()
-					return
-				}
-				if pub.Offset > 0 {
-					s.offset = pub.Offset
-				}
-				s.mu.Unlock()
-				var handler PublicationHandler
-				if s.events != nil && s.events.onPublication != nil {
-					handler = s.events.onPublication
-				}
-				if handler != nil {
-					handler(PublicationEvent{Publication: pubFromProto(pub)})
-				}
-			}
-		})
+	if gap != nil {
+		// Server could not recover publications missed after gap.
+		s.emitGap(*gap)
+	}
+
+	if startCatchUp {
+		go s.catchUp(catchUpID, *from, res.Offset)
+	}
+
+	// Recovered publications pass tags filter like live ones.
+	for _, pub := range res.Publications {
+		s.mu.Lock()
+		if s.state != SubStateSubscribed {
+			s.mu.Unlock()
+			return
+		}
+		if pub.Offset > 0 {
+			s.offset = pub.Offset
+		}
+		var pos *StreamPosition
+		if res.Recoverable && pub.Offset > 0 {
+			pos = &StreamPosition{Offset: s.offset, Epoch: s.epoch}
+		}
+		s.mu.Unlock()
+		s.emitPublication(pubFromProto(pub), pos)
 	}
 }
 
 // Lock must be held outside.
 func (s *Subscription) scheduleResubscribe() {
-	delay := s.resubscribeStrategy.timeBeforeNextAttempt(s.resubscribeAttempts)
 	s.resubscribeAttempts++
+	delay, ok := s.resubscribeStrategy.TimeBeforeNextAttempt(s.resubscribeAttempts)
+	if !ok {
+		s.resolveSubFutures(ErrSubscriptionUnsubscribed)
+		go s.unsubscribe(unsubscribedResubscribeStopped, "resubscribe stopped", false)
+		return
+	}
 	s.resubscribeTimer = time.AfterFunc(delay, func() {
 		s.mu.Lock()
 		if s.state != SubStateSubscribing {
@@ -527,7 +684,7 @@ func (s *Subscription) subscribeError(err error) {
 	s.mu.Unlock()
 
 	if err == ErrTimeout {
-		go s.centrifuge.handleDisconnect(&disconnect{Code: connectingSubscribeTimeout, Reason: "subscribe timeout", Reconnect: true})
+		go s.centrifuge.handleDisconnect(&Disconnect{Code: connectingSubscribeTimeout, Reason: "subscribe timeout", Reconnect: true})
 		return
 	}
 
@@ -535,6 +692,9 @@ func (s *Subscription) subscribeError(err error) {
 
 	var serverError *Error
 	if errors.As(err, &serverError) {
+		// Token may be the reason server rejected subscribe, next attempt
+		// gets a new one.
+		s.centrifuge.subTokens.delete(s.Channel)
 		if serverError.Code == 109 { // Token expired.
 			s.mu.Lock()
 			s.token = ""
@@ -559,8 +719,8 @@ func (s *Subscription) subscribeError(err error) {
 
 // Lock must be held outside.
 func (s *Subscription) emitError(err error) {
-	if s.events != nil && s.events.onError != nil {
-		handler := s.events.onError
+	if events := s.events.load(); events.onError != nil {
+		handler := events.onError
 		s.centrifuge.runHandlerSync(func() {
 			handler(SubscriptionErrorEvent{Error: err})
 		})
@@ -573,27 +733,230 @@ func (s *Subscription) handlePublication(pub *protocol.Publication) {
 		s.mu.Unlock()
 		return
 	}
+	if s.catchingUp {
+		s.catchUpBuf = append(s.catchUpBuf, pub)
+		s.mu.Unlock()
+		return
+	}
+	var gap *GapEvent
+	if s.streamPositioned && pub.Offset > 0 {
+		if pub.Offset <= s.offset {
+			// Duplicate of already delivered publication.
+			s.mu.Unlock()
+			return
+		}
+		if pub.Offset > s.offset+1 {
+			gap = &GapEvent{FromOffset: s.offset + 1, ToOffset: pub.Offset - 1}
+			if s.resubscribeOnGap {
+				var from *StreamPosition
+				if s.epoch != "" {
+					from = &StreamPosition{Offset: s.offset, Epoch: s.epoch}
+				}
+				s.mu.Unlock()
+				if from == nil {
+					// Can't recover without epoch, so report missed range.
+					s.emitGap(*gap)
+				}
+				s.resubscribeAfterGap(from)
+				return
+			}
+		}
+	}
 	if pub.Offset > 0 {
 		s.offset = pub.Offset
 	}
+	var pos *StreamPosition
+	if s.recover && pub.Offset > 0 {
+		pos = &StreamPosition{Offset: s.offset, Epoch: s.epoch}
+	}
 	s.mu.Unlock()
 
+	if gap != nil {
+		s.emitGap(*gap)
+	}
+	s.emitPublication(pubFromProto(pub), pos)
+}
+
+func (s *Subscription) emitGap(ev GapEvent) {
+	if events := s.events.load(); events.onGap != nil {
+		handler := events.onGap
+		s.centrifuge.runHandlerSync(func() {
+			handler(ev)
+		})
+	}
+}
+
+// resubscribeAfterGap unsubscribes and subscribes again. Non-nil from is the
+// last delivered position to recover missed publications from.
+func (s *Subscription) resubscribeAfterGap(from *StreamPosition) {
+	s.centrifuge.unsubscribe(s.Channel, func(result UnsubscribeResult, err error) {
+		if err != nil {
+			go s.centrifuge.handleDisconnect(&Disconnect{Code: connectingUnsubscribeError, Reason: "unsubscribe error", Reconnect: true})
+			return
+		}
+	})
+	s.moveToSubscribing(subscribingPublicationGap, "publication gap")
+	s.mu.Lock()
+	s.gapRecoverFrom = from
+	s.mu.Unlock()
+	go s.resubscribe()
+}
+
+// emitPublication calls publication handler if publication matches tags filter.
+// Non-nil pos is saved to PositionStore after handler returned, so position
+// never gets ahead of publications processed by application.
+func (s *Subscription) emitPublication(pub Publication, pos *StreamPosition) {
 	var handler PublicationHandler
-	if s.events != nil && s.events.onPublication != nil {
-		handler = s.events.onPublication
+	if s.tagsFilter == nil || s.tagsFilter.Match(pub.Tags) {
+		if events := s.events.load(); events.onPublication != nil {
+			handler = events.onPublication
+		}
 	}
-	if handler == nil {
+	if handler == nil && pos == nil {
 		return
 	}
 	s.centrifuge.runHandlerSync(func() {
-		handler(PublicationEvent{Publication: pubFromProto(pub)})
+		if handler != nil {
+			handler(PublicationEvent{Publication: pub})
+		}
+		if pos != nil {
+			s.centrifuge.savePosition(s.Channel, *pos)
+		}
 	})
 }
 
+// historyCatchUpLimit is a number of publications requested from history at
+// once during catch-up.
+const historyCatchUpLimit = 100
+
+// catchUp loads publications missed after failed recovery from history and
+// delivers them, then delivers publications buffered meanwhile.
+func (s *Subscription) catchUp(id uint64, from StreamPosition, top uint64) {
+	pos := from
+	for pos.Offset < top {
+		res, err := s.centrifuge.History(context.Background(), s.Channel, WithHistorySince(&pos), WithHistoryLimit(historyCatchUpLimit))
+		if err != nil {
+			s.failCatchUp(id, err)
+			return
+		}
+		if res.Epoch != from.Epoch {
+			s.failCatchUp(id, errors.New("stream epoch changed"))
+			return
+		}
+		if len(res.Publications) == 0 || res.Publications[len(res.Publications)-1].Offset <= pos.Offset {
+			// History has no publications after position (e.g. it was
+			// trimmed), asking again won't help. Report skipped range and
+			// go on with buffered publications.
+			if !s.skipCatchUp(id, GapEvent{FromOffset: pos.Offset + 1, ToOffset: top}) {
+				return
+			}
+			break
+		}
+		for _, pub := range res.Publications {
+			if pub.Offset > top {
+				break
+			}
+			if !s.deliverCatchUp(id, pub) {
+				return
+			}
+		}
+		pos.Offset = res.Publications[len(res.Publications)-1].Offset
+	}
+	for {
+		s.mu.Lock()
+		if s.catchUpID != id || !s.catchingUp {
+			s.mu.Unlock()
+			return
+		}
+		if len(s.catchUpBuf) == 0 {
+			s.catchingUp = false
+			s.mu.Unlock()
+			return
+		}
+		pubs := s.catchUpBuf
+		s.catchUpBuf = nil
+		s.mu.Unlock()
+		for _, pub := range pubs {
+			if !s.deliverCatchUp(id, pubFromProto(pub)) {
+				return
+			}
+		}
+	}
+}
+
+// skipCatchUp moves position over offsets missing in history reporting them
+// in OnError and OnGap. It returns false if catch-up was cancelled.
+func (s *Subscription) skipCatchUp(id uint64, gap GapEvent) bool {
+	s.mu.Lock()
+	if s.catchUpID != id || !s.catchingUp || s.state != SubStateSubscribed {
+		s.mu.Unlock()
+		return false
+	}
+	if gap.ToOffset > s.offset {
+		s.offset = gap.ToOffset
+	}
+	s.mu.Unlock()
+	s.emitError(SubscriptionCatchUpError{Err: fmt.Errorf("history has no publications with offsets %d-%d", gap.FromOffset, gap.ToOffset)})
+	s.emitGap(gap)
+	return true
+}
+
+// failCatchUp reports catch-up error and resubscribes from the last delivered position.
+func (s *Subscription) failCatchUp(id uint64, err error) {
+	s.mu.Lock()
+	if s.catchUpID != id || !s.catchingUp || s.state != SubStateSubscribed {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.emitError(SubscriptionCatchUpError{Err: err})
+	s.centrifuge.unsubscribe(s.Channel, func(result UnsubscribeResult, err error) {
+		if err != nil {
+			go s.centrifuge.handleDisconnect(&Disconnect{Code: connectingUnsubscribeError, Reason: "unsubscribe error", Reconnect: true})
+			return
+		}
+	})
+	s.moveToSubscribing(subscribingCatchUpError, "history catch-up error")
+	s.mu.Lock()
+	if s.state == SubStateSubscribing {
+		// Resubscribe with backoff as History may keep failing.
+		s.scheduleResubscribe()
+	}
+	s.mu.Unlock()
+}
+
+// deliverCatchUp delivers publication during catch-up skipping ones already
+// delivered. It returns false if catch-up was cancelled.
+func (s *Subscription) deliverCatchUp(id uint64, pub Publication) bool {
+	s.mu.Lock()
+	if s.catchUpID != id || !s.catchingUp || s.state != SubStateSubscribed {
+		s.mu.Unlock()
+		return false
+	}
+	if pub.Offset > 0 && pub.Offset <= s.offset {
+		s.mu.Unlock()
+		return true
+	}
+	if pub.Offset > 0 {
+		s.offset = pub.Offset
+	}
+	var pos *StreamPosition
+	if s.recover && pub.Offset > 0 {
+		pos = &StreamPosition{Offset: s.offset, Epoch: s.epoch}
+	}
+	s.mu.Unlock()
+
+	s.emitPublication(pub, pos)
+	return true
+}
+
 func (s *Subscription) handleJoin(info *protocol.ClientInfo) {
+	if s.presenceTracker != nil {
+		s.trackPresence(presenceChange{info: infoFromProto(info)})
+	}
 	var handler JoinHandler
-	if s.events != nil && s.events.onJoin != nil {
-		handler = s.events.onJoin
+	if events := s.events.load(); events.onJoin != nil {
+		handler = events.onJoin
 	}
 	if handler != nil {
 		s.centrifuge.runHandlerSync(func() {
@@ -603,9 +966,12 @@ func (s *Subscription) handleJoin(info *protocol.ClientInfo) {
 }
 
 func (s *Subscription) handleLeave(info *protocol.ClientInfo) {
+	if s.presenceTracker != nil {
+		s.trackPresence(presenceChange{info: infoFromProto(info), leave: true})
+	}
 	var handler LeaveHandler
-	if s.events != nil && s.events.onLeave != nil {
-		handler = s.events.onLeave
+	if events := s.events.load(); events.onLeave != nil {
+		handler = events.onLeave
 	}
 	if handler != nil {
 		s.centrifuge.runHandlerSync(func() {
@@ -624,46 +990,89 @@ func (s *Subscription) handleUnsubscribe(unsubscribe *protocol.Unsubscribe) {
 }
 
 func (s *Subscription) resubscribe() {
+	token, ok := s.resubscribeToken()
+	if !ok {
+		return
+	}
+	s.sendResubscribe(token, func() {})
+}
+
+// resubscribeToken returns token to subscribe with, using cached token or
+// calling GetToken when token required. It returns false when subscribe
+// should not be sent.
+func (s *Subscription) resubscribeToken() (string, bool) {
 	s.mu.Lock()
 	if s.state != SubStateSubscribing {
 		s.mu.Unlock()
-		return
+		return "", false
 	}
 	token := s.token
 	s.mu.Unlock()
 
-	if token == "" && s.getToken != nil {
+	if s.getToken != nil {
+		var ok bool
+		token, ok = s.centrifuge.subTokens.get(s.Channel)
+		if ok {
+			s.mu.Lock()
+			s.token = token
+			s.mu.Unlock()
+			return token, true
+		}
 		var err error
-		token, err = s.getSubscriptionToken(s.Channel)
+		token, err = s.centrifuge.subTokens.do(s.Channel, func() (string, error) {
+			return s.getSubscriptionToken(s.Channel)
+		})
 		if err != nil {
 			s.subscribeError(err)
-			return
+			return "", false
 		}
 		s.mu.Lock()
 		if token == "" {
 			s.unsubscribe(unsubscribedUnauthorized, "unauthorized", true)
 			s.mu.Unlock()
-			return
+			return "", false
 		}
 		s.token = token
 		s.mu.Unlock()
 	}
+	return token, true
+}
 
+// sendResubscribe sends subscribe command. Done called when subscribe reply
+// received or command was not sent, it may be called more than once.
+func (s *Subscription) sendResubscribe(token string, done func()) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.state != SubStateSubscribing {
+		done()
 		return
 	}
 
 	var isRecover bool
 	var sp StreamPosition
-	if s.recover {
+	if s.gapRecoverFrom != nil {
+		isRecover = true
+		sp = *s.gapRecoverFrom
+	} else if s.recover {
 		isRecover = true
 		sp.Offset = s.offset
 		sp.Epoch = s.epoch
+	} else if store := s.centrifuge.config.PositionStore; store != nil {
+		pos, ok, err := store.Load(s.Channel)
+		if err != nil {
+			s.centrifuge.handleErrorAsync(fmt.Errorf("error loading stream position: %w", err))
+		} else if ok {
+			isRecover = true
+			sp = pos
+		}
+	}
+	s.recoverFrom = nil
+	if isRecover {
+		s.recoverFrom = &StreamPosition{Offset: sp.Offset, Epoch: sp.Epoch}
 	}
 
 	err := s.centrifuge.sendSubscribe(s.Channel, s.data, isRecover, sp, token, s.positioned, s.recoverable, s.joinLeave, func(res *protocol.SubscribeResult, err error) {
+		defer done()
 		if err != nil {
 			s.subscribeError(err)
 			return
@@ -672,13 +1081,17 @@ func (s *Subscription) resubscribe() {
 	})
 	if err != nil {
 		s.scheduleResubscribe()
+		done()
 	}
 }
 
 func (s *Subscription) getSubscriptionToken(channel string) (string, error) {
 	handler := s.getToken
 	if handler != nil {
+		ctx, cancel := s.centrifuge.tokenContext()
+		defer cancel()
 		ev := SubscriptionTokenEvent{
+			Context: ctx,
 			Channel: channel,
 		}
 		return handler(ev)
@@ -687,11 +1100,11 @@ func (s *Subscription) getSubscriptionToken(channel string) (string, error) {
 }
 
 // Lock must be held outside.
-func (s *Subscription) scheduleSubRefresh(ttl uint32) {
+func (s *Subscription) scheduleSubRefresh(delay time.Duration) {
 	if s.state != SubStateSubscribed {
 		return
 	}
-	s.refreshTimer = time.AfterFunc(time.Duration(ttl)*time.Second, func() {
+	s.refreshTimer = time.AfterFunc(delay, func() {
 		s.mu.Lock()
 		if s.state != SubStateSubscribed {
 			s.mu.Unlock()
@@ -699,12 +1112,14 @@ func (s *Subscription) scheduleSubRefresh(ttl uint32) {
 		}
 		s.mu.Unlock()
 
-		token, err := s.getSubscriptionToken(s.Channel)
+		token, err := s.centrifuge.subTokens.do(s.Channel, func() (string, error) {
+			return s.getSubscriptionToken(s.Channel)
+		})
 		if err != nil {
 			s.mu.Lock()
 			defer s.mu.Unlock()
 			s.emitError(SubscriptionRefreshError{Err: err})
-			s.scheduleSubRefresh(10)
+			s.scheduleSubRefresh(10 * time.Second)
 			return
 		}
 		if token == "" {
@@ -722,7 +1137,7 @@ func (s *Subscription) scheduleSubRefresh(ttl uint32) {
 					if serverError.Temporary {
 						s.mu.Lock()
 						defer s.mu.Unlock()
-						s.scheduleSubRefresh(10)
+						s.scheduleSubRefresh(10 * time.Second)
 						return
 					} else {
 						s.mu.Lock()
@@ -733,15 +1148,18 @@ func (s *Subscription) scheduleSubRefresh(ttl uint32) {
 				} else {
 					s.mu.Lock()
 					defer s.mu.Unlock()
-					s.scheduleSubRefresh(10)
+					s.scheduleSubRefresh(10 * time.Second)
 					return
 				}
 			}
+			s.mu.Lock()
+			s.token = token
 			if result.Expires {
-				s.mu.Lock()
-				s.scheduleSubRefresh(result.Ttl)
-				s.mu.Unlock()
+				delay := s.centrifuge.refreshDelay(result.Ttl)
+				s.centrifuge.subTokens.set(s.Channel, token, delay)
+				s.scheduleSubRefresh(delay)
 			}
+			s.mu.Unlock()
 		})
 	})
 }
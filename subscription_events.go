@@ -1,5 +1,7 @@
 package centrifuge
 
+import "sync/atomic"
+
 // SubscribedEvent is an event context passed
 // to subscribe success callback.
 type SubscribedEvent struct {
@@ -44,6 +46,18 @@ type PublicationEvent struct {
 	Publication
 }
 
+// GapEvent has info about publications missed in positioned Subscription
+// stream. FromOffset and ToOffset define inclusive range of missed offsets.
+type GapEvent struct {
+	FromOffset uint64
+	ToOffset   uint64
+}
+
+// PresenceChangedEvent has current channel presence after a change.
+type PresenceChangedEvent struct {
+	PresenceRoster
+}
+
 // PublicationHandler is a function to handle messages published in
 // channels.
 type PublicationHandler func(PublicationEvent)
@@ -66,54 +80,267 @@ type SubscribedHandler func(SubscribedEvent)
 // SubscriptionErrorHandler is a function to handle subscribe error event.
 type SubscriptionErrorHandler func(SubscriptionErrorEvent)
 
+// GapHandler is a function to handle gap event.
+type GapHandler func(GapEvent)
+
+// PresenceChangedHandler is a function to handle presence changed event.
+type PresenceChangedHandler func(PresenceChangedEvent)
+
 // subscriptionEventHub contains callback functions that will be called when
 // corresponding event happens with subscription to channel.
 type subscriptionEventHub struct {
-	onSubscribing SubscribingHandler
-	onSubscribed  SubscribedHandler
-	onUnsubscribe UnsubscribedHandler
-	onError       SubscriptionErrorHandler
-	onPublication PublicationHandler
-	onJoin        JoinHandler
-	onLeave       LeaveHandler
+	// dispatch has *subscriptionEventDispatch built by rebuild. It's replaced
+	// as a whole, so handlers can be added and removed while events
+	// dispatched.
+	dispatch atomic.Value
+
+	// handlers has handlers set by user which are called by dispatch
+	// together with sending events to channels.
+	handlers subscriptionEventHandlers
+	pubChans []*publicationChan
+	registry handlerRegistry
+}
+
+// subscriptionEventDispatch has functions called when corresponding event
+// happens with subscription.
+type subscriptionEventDispatch struct {
+	onSubscribing     SubscribingHandler
+	onSubscribed      SubscribedHandler
+	onUnsubscribe     UnsubscribedHandler
+	onError           SubscriptionErrorHandler
+	onPublication     PublicationHandler
+	onJoin            JoinHandler
+	onLeave           LeaveHandler
+	onGap             GapHandler
+	onPresenceChanged PresenceChangedHandler
+}
+
+// subscriptionEventHandlers has event handlers set by user.
+type subscriptionEventHandlers struct {
+	onSubscribing     handlerList
+	onSubscribed      handlerList
+	onUnsubscribe     handlerList
+	onError           handlerList
+	onPublication     handlerList
+	onJoin            handlerList
+	onLeave           handlerList
+	onGap             handlerList
+	onPresenceChanged handlerList
 }
 
 // newSubscriptionEventHub initializes new subscriptionEventHub.
 func newSubscriptionEventHub() *subscriptionEventHub {
-	return &subscriptionEventHub{}
+	h := &subscriptionEventHub{}
+	h.dispatch.Store(&subscriptionEventDispatch{})
+	return h
+}
+
+// load returns current handlers to call on events.
+func (h *subscriptionEventHub) load() *subscriptionEventDispatch {
+	return h.dispatch.Load().(*subscriptionEventDispatch)
+}
+
+// rebuild publishes new dispatch calling user handlers in registration order
+// and sending publications to event channels. Registry lock must be held
+// outside.
+func (h *subscriptionEventHub) rebuild() {
+	d := &subscriptionEventDispatch{}
+	if fns := h.handlers.onSubscribing.fns(); len(fns) > 0 {
+		d.onSubscribing = func(e SubscribingEvent) {
+			for _, fn := range fns {
+				if fn := fn.(SubscribingHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	if fns := h.handlers.onSubscribed.fns(); len(fns) > 0 {
+		d.onSubscribed = func(e SubscribedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(SubscribedHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	if fns := h.handlers.onUnsubscribe.fns(); len(fns) > 0 {
+		d.onUnsubscribe = func(e UnsubscribedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(UnsubscribedHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	if fns := h.handlers.onError.fns(); len(fns) > 0 {
+		d.onError = func(e SubscriptionErrorEvent) {
+			for _, fn := range fns {
+				if fn := fn.(SubscriptionErrorHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	pubChans := h.pubChans
+	if fns := h.handlers.onPublication.fns(); len(fns) > 0 || len(pubChans) > 0 {
+		d.onPublication = func(e PublicationEvent) {
+			for _, fn := range fns {
+				if fn := fn.(PublicationHandler); fn != nil {
+					fn(e)
+				}
+			}
+			for _, c := range pubChans {
+				c.send(e)
+			}
+		}
+	}
+	if fns := h.handlers.onJoin.fns(); len(fns) > 0 {
+		d.onJoin = func(e JoinEvent) {
+			for _, fn := range fns {
+				if fn := fn.(JoinHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	if fns := h.handlers.onLeave.fns(); len(fns) > 0 {
+		d.onLeave = func(e LeaveEvent) {
+			for _, fn := range fns {
+				if fn := fn.(LeaveHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	if fns := h.handlers.onGap.fns(); len(fns) > 0 {
+		d.onGap = func(e GapEvent) {
+			for _, fn := range fns {
+				if fn := fn.(GapHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	if fns := h.handlers.onPresenceChanged.fns(); len(fns) > 0 {
+		d.onPresenceChanged = func(e PresenceChangedEvent) {
+			for _, fn := range fns {
+				if fn := fn.(PresenceChangedHandler); fn != nil {
+					fn(e)
+				}
+			}
+		}
+	}
+	h.dispatch.Store(d)
 }
 
 // OnSubscribing allows setting SubscribingHandler to SubEventHandler.
 func (s *Subscription) OnSubscribing(handler SubscribingHandler) {
-	s.events.onSubscribing = handler
+	s.events.registry.set(&s.events.handlers.onSubscribing, handler, s.events.rebuild)
+}
+
+// AddSubscribingHandler adds one more function to handle subscribing events.
+// Handlers are called in order of registration, handler set by OnSubscribing
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddSubscribingHandler(handler SubscribingHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onSubscribing, handler, s.events.rebuild)
 }
 
 // OnSubscribed allows setting SubscribedHandler to SubEventHandler.
 func (s *Subscription) OnSubscribed(handler SubscribedHandler) {
-	s.events.onSubscribed = handler
+	s.events.registry.set(&s.events.handlers.onSubscribed, handler, s.events.rebuild)
+}
+
+// AddSubscribedHandler adds one more function to handle subscribed events.
+// Handlers are called in order of registration, handler set by OnSubscribed
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddSubscribedHandler(handler SubscribedHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onSubscribed, handler, s.events.rebuild)
 }
 
 // OnUnsubscribed allows setting UnsubscribedHandler to SubEventHandler.
 func (s *Subscription) OnUnsubscribed(handler UnsubscribedHandler) {
-	s.events.onUnsubscribe = handler
+	s.events.registry.set(&s.events.handlers.onUnsubscribe, handler, s.events.rebuild)
+}
+
+// AddUnsubscribedHandler adds one more function to handle unsubscribed events.
+// Handlers are called in order of registration, handler set by OnUnsubscribed
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddUnsubscribedHandler(handler UnsubscribedHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onUnsubscribe, handler, s.events.rebuild)
 }
 
 // OnError allows setting SubscriptionErrorHandler to SubEventHandler.
 func (s *Subscription) OnError(handler SubscriptionErrorHandler) {
-	s.events.onError = handler
+	s.events.registry.set(&s.events.handlers.onError, handler, s.events.rebuild)
+}
+
+// AddErrorHandler adds one more function to handle subscription errors.
+// Handlers are called in order of registration, handler set by OnError
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddErrorHandler(handler SubscriptionErrorHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onError, handler, s.events.rebuild)
 }
 
 // OnPublication allows setting PublicationHandler to SubEventHandler.
 func (s *Subscription) OnPublication(handler PublicationHandler) {
-	s.events.onPublication = handler
+	s.events.registry.set(&s.events.handlers.onPublication, handler, s.events.rebuild)
+}
+
+// AddPublicationHandler adds one more function to handle publications.
+// Handlers are called in order of registration, handler set by OnPublication
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddPublicationHandler(handler PublicationHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onPublication, handler, s.events.rebuild)
 }
 
 // OnJoin allows setting JoinHandler to SubEventHandler.
 func (s *Subscription) OnJoin(handler JoinHandler) {
-	s.events.onJoin = handler
+	s.events.registry.set(&s.events.handlers.onJoin, handler, s.events.rebuild)
+}
+
+// AddJoinHandler adds one more function to handle join events.
+// Handlers are called in order of registration, handler set by OnJoin
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddJoinHandler(handler JoinHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onJoin, handler, s.events.rebuild)
 }
 
 // OnLeave allows setting LeaveHandler to SubEventHandler.
 func (s *Subscription) OnLeave(handler LeaveHandler) {
-	s.events.onLeave = handler
+	s.events.registry.set(&s.events.handlers.onLeave, handler, s.events.rebuild)
+}
+
+// AddLeaveHandler adds one more function to handle leave events.
+// Handlers are called in order of registration, handler set by OnLeave
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddLeaveHandler(handler LeaveHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onLeave, handler, s.events.rebuild)
+}
+
+// OnGap allows setting GapHandler to SubEventHandler. It's called when a gap
+// in publication offsets detected and SubscriptionConfig.ResubscribeOnGap is
+// not set.
+func (s *Subscription) OnGap(handler GapHandler) {
+	s.events.registry.set(&s.events.handlers.onGap, handler, s.events.rebuild)
+}
+
+// AddGapHandler adds one more function to handle publication gaps.
+// Handlers are called in order of registration, handler set by OnGap
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddGapHandler(handler GapHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onGap, handler, s.events.rebuild)
+}
+
+// OnPresenceChanged allows setting PresenceChangedHandler to SubEventHandler.
+// It's called when presence roster of Subscription with
+// SubscriptionConfig.TrackPresence on changed.
+func (s *Subscription) OnPresenceChanged(handler PresenceChangedHandler) {
+	s.events.registry.set(&s.events.handlers.onPresenceChanged, handler, s.events.rebuild)
+}
+
+// AddPresenceChangedHandler adds one more function to handle presence roster changes.
+// Handlers are called in order of registration, handler set by OnPresenceChanged
+// keeps its position. Call returned function to remove handler.
+func (s *Subscription) AddPresenceChangedHandler(handler PresenceChangedHandler) (remove func()) {
+	return s.events.registry.add(&s.events.handlers.onPresenceChanged, handler, s.events.rebuild)
 }
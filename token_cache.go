@@ -0,0 +1,112 @@
+package centrifuge
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriptionTokenCache keeps subscription tokens of Client by channel, so
+// tokens are reused on resubscribe while valid, and deduplicates concurrent
+// token requests for the same channel. Tokens may be bound to client ID, so
+// cache is cleared when Client gets new one.
+type subscriptionTokenCache struct {
+	mu     sync.Mutex
+	client string
+	// gen changes with client ID, so tokens requested for previous one
+	// are not cached.
+	gen    uint64
+	tokens map[string]cachedToken
+	calls  map[string]*tokenCall
+}
+
+type cachedToken struct {
+	token string
+	// expireAt is zero when token valid until invalidated.
+	expireAt time.Time
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+func newSubscriptionTokenCache() *subscriptionTokenCache {
+	return &subscriptionTokenCache{
+		tokens: make(map[string]cachedToken),
+		calls:  make(map[string]*tokenCall),
+	}
+}
+
+// setClient clears cache if client ID changed. Tokens set before the first
+// connect are kept as they can't be bound to client ID not known yet.
+func (c *subscriptionTokenCache) setClient(client string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client == c.client {
+		return
+	}
+	if c.client != "" {
+		c.tokens = make(map[string]cachedToken)
+		c.gen++
+	}
+	c.client = client
+}
+
+func (c *subscriptionTokenCache) get(channel string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tokens[channel]
+	if !ok {
+		return "", false
+	}
+	if !t.expireAt.IsZero() && !time.Now().Before(t.expireAt) {
+		delete(c.tokens, channel)
+		return "", false
+	}
+	return t.token, true
+}
+
+// set caches token for ttl, zero ttl means until invalidated.
+func (c *subscriptionTokenCache) set(channel string, token string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := cachedToken{token: token}
+	if ttl > 0 {
+		t.expireAt = time.Now().Add(ttl)
+	}
+	c.tokens[channel] = t
+}
+
+func (c *subscriptionTokenCache) delete(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, channel)
+}
+
+// do calls fn to get token for channel. Concurrent calls for the same channel
+// wait for the first one and share its result. Non-empty token is cached
+// until subscribe or refresh result tells its TTL.
+func (c *subscriptionTokenCache) do(channel string, fn func() (string, error)) (string, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[channel]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	c.calls[channel] = call
+	gen := c.gen
+	c.mu.Unlock()
+
+	call.token, call.err = fn()
+
+	c.mu.Lock()
+	delete(c.calls, channel)
+	if call.err == nil && call.token != "" && gen == c.gen {
+		c.tokens[channel] = cachedToken{token: call.token}
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.token, call.err
+}
@@ -1,15 +1,26 @@
 package centrifuge
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/centrifugal/protocol"
 )
 
-type transport interface {
+// Transport is a connection to a server over which Client sends commands
+// and receives replies. Websocket, HTTP-streaming and SSE transports are
+// available out of the box, custom implementations may be set over
+// Config.TransportFactory.
+type Transport interface {
 	// Read should read new Reply messages from connection.
 	// It should not be thread-safe as we will call it from one goroutine.
-	Read() (*protocol.Reply, *disconnect, error)
+	// When connection closed Read must return non-nil error and may return
+	// Disconnect to tell Client how to proceed.
+	Read() (*protocol.Reply, *Disconnect, error)
 	// Write should write Command to connection with specified write timeout.
 	// It should not be thread-safe as we will call it from one goroutine.
 	Write(cmd *protocol.Command, timeout time.Duration) error
@@ -18,3 +29,130 @@ type transport interface {
 	// and Write methods.
 	Close() error
 }
+
+// TransportFactory creates new Transport connected to the endpoint. Client
+// calls it on every connection attempt.
+type TransportFactory func(endpoint string, protocolType protocol.Type, config Config) (Transport, error)
+
+// TransportType is a type of transport shipped with this package.
+type TransportType string
+
+// Transport types which can be used in Config.Transports.
+const (
+	TransportWebsocket  TransportType = "websocket"
+	TransportHTTPStream TransportType = "http_stream"
+	TransportSSE        TransportType = "sse"
+)
+
+// transportOptions are passed by Client to transports shipped with this
+// package in addition to Config.
+type transportOptions struct {
+	// httpClient is shared by HTTP-based transports of Client, so connections
+	// to a server are reused between reconnects.
+	httpClient *http.Client
+	// connectCommand allows transports which send connect command upon
+	// opening connection to do it on creation.
+	connectCommand *protocol.Command
+}
+
+// transportFactory is a TransportFactory which also accepts transportOptions.
+type transportFactory func(endpoint string, protocolType protocol.Type, config Config, opts transportOptions) (Transport, error)
+
+// withoutOptions adapts TransportFactory which has no use of transportOptions.
+func withoutOptions(factory TransportFactory) transportFactory {
+	return func(endpoint string, protocolType protocol.Type, config Config, _ transportOptions) (Transport, error) {
+		return factory(endpoint, protocolType, config)
+	}
+}
+
+var transportFactories = map[TransportType]transportFactory{
+	TransportWebsocket:  withoutOptions(NewWebsocketTransport),
+	TransportHTTPStream: newHTTPStreamTransport,
+	TransportSSE:        newSSETransport,
+}
+
+// TransportEndpoint describes transport and endpoint pair to connect with.
+type TransportEndpoint struct {
+	// Transport type to use.
+	Transport TransportType
+	// Endpoint of a server for the Transport.
+	Endpoint string
+	// Factory allows setting custom TransportFactory for the endpoint. In this
+	// case Transport is only used to identify the endpoint in events.
+	Factory TransportFactory
+}
+
+// transportTypeFromEndpoint returns transport type to use by default for
+// endpoint according to its scheme.
+func transportTypeFromEndpoint(endpoint string) TransportType {
+	if strings.HasPrefix(endpoint, "http") {
+		return TransportHTTPStream
+	}
+	return TransportWebsocket
+}
+
+// validateEndpoint checks endpoint of transport shipped with this package.
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("malformed endpoint %q: %v", endpoint, err)
+	}
+	switch u.Scheme {
+	case "ws", "wss", "http", "https":
+	default:
+		return fmt.Errorf("unsupported endpoint scheme: %s", endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("endpoint host required: %s", endpoint)
+	}
+	return nil
+}
+
+// newTransport is a default transportFactory which selects transport by
+// endpoint scheme.
+func newTransport(endpoint string, protocolType protocol.Type, config Config, opts transportOptions) (Transport, error) {
+	return transportFactories[transportTypeFromEndpoint(endpoint)](endpoint, protocolType, config, opts)
+}
+
+// Disconnect describes the reason of connection close.
+type Disconnect struct {
+	// Code of disconnect.
+	Code uint32
+	// Reason of disconnect.
+	Reason string
+	// Reconnect tells Client whether it should reconnect.
+	Reconnect bool
+}
+
+// retryAfterFromResponse extracts delay from Retry-After header of response.
+// Both delay in seconds and HTTP-date formats are supported.
+func retryAfterFromResponse(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// withRetryAfter wraps connection error into RetryAfterError when server
+// asked to retry later.
+func withRetryAfter(err error, resp *http.Response) error {
+	if resp == nil {
+		return err
+	}
+	if d := retryAfterFromResponse(resp); d > 0 {
+		return &RetryAfterError{Err: err, RetryAfter: d}
+	}
+	return err
+}
@@ -0,0 +1,341 @@
+package centrifuge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/centrifugal/protocol"
+)
+
+// defaultEmulationPath is a path of Centrifugo emulation endpoint used when
+// Config.EmulationEndpoint not set.
+const defaultEmulationPath = "/emulation"
+
+// emulationIdleConnTimeout is how long idle connections to a server are kept
+// for reuse by HTTP-based transports.
+const emulationIdleConnTimeout = 90 * time.Second
+
+var errNoEmulationSession = errors.New("no emulation session")
+
+func newHTTPClient(config Config) *http.Client {
+	dialContext := config.NetDialContext
+	if dialContext == nil {
+		dialContext = (&net.Dialer{
+			Timeout:   config.HandshakeTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialContext,
+			IdleConnTimeout:     emulationIdleConnTimeout,
+			TLSClientConfig:     config.TLSConfig,
+			TLSHandshakeTimeout: config.HandshakeTimeout,
+			// Stream response headers are sent by a server as soon as
+			// connection established.
+			ResponseHeaderTimeout: config.HandshakeTimeout,
+		},
+		Jar: config.CookieJar,
+	}
+}
+
+// emulationEndpoint returns URL of emulation endpoint for a connection
+// endpoint.
+func emulationEndpoint(endpoint string, config Config) (string, error) {
+	if config.EmulationEndpoint != "" {
+		return config.EmulationEndpoint, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	u.Path = defaultEmulationPath
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+func contentType(protocolType protocol.Type) string {
+	if protocolType == protocol.TypeJSON {
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+// emulationSender sends commands to a server over Centrifugo bidirectional
+// emulation endpoint. Commands are routed to the connection by session and
+// node received from a server in connect result.
+type emulationSender struct {
+	mu           sync.RWMutex
+	client       *http.Client
+	endpoint     string
+	header       http.Header
+	protocolType protocol.Type
+	session      string
+	node         string
+}
+
+func newEmulationSender(client *http.Client, endpoint string, protocolType protocol.Type, header http.Header) *emulationSender {
+	return &emulationSender{
+		client:       client,
+		endpoint:     endpoint,
+		header:       header,
+		protocolType: protocolType,
+	}
+}
+
+// handleReply remembers emulation session from connect result.
+func (s *emulationSender) handleReply(reply *protocol.Reply) {
+	if reply.Connect == nil {
+		return
+	}
+	s.mu.Lock()
+	s.session = reply.Connect.Session
+	s.node = reply.Connect.Node
+	s.mu.Unlock()
+}
+
+func (s *emulationSender) encode(data []byte) ([]byte, error) {
+	s.mu.RLock()
+	req := &protocol.EmulationRequest{
+		Session: s.session,
+		Node:    s.node,
+		Data:    data,
+	}
+	s.mu.RUnlock()
+	if req.Session == "" {
+		return nil, errNoEmulationSession
+	}
+	if s.protocolType == protocol.TypeJSON {
+		return json.Marshal(req)
+	}
+	return req.MarshalVT()
+}
+
+// Send sends encoded command data over emulation endpoint.
+func (s *emulationSender) Send(data []byte, timeout time.Duration) error {
+	body, err := s.encode(data)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType(s.protocolType))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("wrong status code from emulation endpoint: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// streamDialer opens response stream sending first command data to a server.
+type streamDialer func(ctx context.Context, data []byte) (io.ReadCloser, error)
+
+// streamReader reads one Reply from response stream. It may return nil Reply
+// for stream frames without replies.
+type streamReader func(r *bufio.Reader) (*protocol.Reply, error)
+
+// emulationTransport is a base for transports which receive replies in HTTP
+// response stream and send commands over emulation endpoint. The first command
+// written (connect) is passed to a server upon opening response stream.
+type emulationTransport struct {
+	mu             sync.Mutex
+	commandEncoder protocol.CommandEncoder
+	client         *http.Client
+	ownClient      bool
+	emulation      *emulationSender
+	dial           streamDialer
+	readReply      streamReader
+	replyCh        chan *protocol.Reply
+	disconnect     *Disconnect
+	started        bool
+	connectID      uint32
+	closed         bool
+	closeCh        chan struct{}
+	ctx            context.Context
+	cancel         context.CancelFunc
+}
+
+func newEmulationTransport(endpoint string, protocolType protocol.Type, config Config, opts transportOptions, dial func(client *http.Client) streamDialer, readReply streamReader) (*emulationTransport, error) {
+	emulationURL, err := emulationEndpoint(endpoint, config)
+	if err != nil {
+		return nil, fmt.Errorf("error emulation endpoint: %v", err)
+	}
+	// Transport created outside of Client has its own HTTP client.
+	client := opts.httpClient
+	ownClient := client == nil
+	if ownClient {
+		client = newHTTPClient(config)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &emulationTransport{
+		commandEncoder: newCommandEncoder(protocolType),
+		client:         client,
+		ownClient:      ownClient,
+		emulation:      newEmulationSender(client, emulationURL, protocolType, config.Header),
+		dial:           dial(client),
+		readReply:      readReply,
+		replyCh:        make(chan *protocol.Reply),
+		closeCh:        make(chan struct{}),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	if opts.connectCommand != nil {
+		// Client passes connect command to open stream right away, so
+		// connection is established here as websocket dial does and not
+		// on first Write.
+		data, err := t.commandEncoder.Encode(opts.connectCommand)
+		if err != nil {
+			_ = t.Close()
+			return nil, err
+		}
+		t.started = true
+		t.connectID = opts.connectCommand.Id
+		if err := t.openStream(data); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *emulationTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.closeCh)
+	started := t.started
+	t.mu.Unlock()
+	t.cancel()
+	if t.ownClient {
+		t.client.CloseIdleConnections()
+	}
+	if !started {
+		// No reader goroutine to close reply channel.
+		close(t.replyCh)
+	}
+	return nil
+}
+
+func (t *emulationTransport) Write(cmd *protocol.Command, timeout time.Duration) error {
+	data, err := t.commandEncoder.Encode(cmd)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return io.EOF
+	}
+	if t.connectID != 0 && cmd.Id == t.connectID {
+		// Connect command was already sent upon opening stream.
+		t.mu.Unlock()
+		return nil
+	}
+	if !t.started {
+		t.started = true
+		t.mu.Unlock()
+		return t.openStream(data)
+	}
+	t.mu.Unlock()
+	return t.emulation.Send(data, timeout)
+}
+
+func (t *emulationTransport) openStream(data []byte) error {
+	body, err := t.dial(t.ctx, data)
+	if err != nil {
+		close(t.replyCh)
+		_ = t.Close()
+		return err
+	}
+	go t.reader(body)
+	return nil
+}
+
+func (t *emulationTransport) reader(body io.ReadCloser) {
+	defer func() { _ = t.Close() }()
+	defer close(t.replyCh)
+	defer func() { _ = body.Close() }()
+
+	r := bufio.NewReader(body)
+	for {
+		reply, err := t.readReply(r)
+		if err != nil {
+			if _, ok := err.(decodeError); ok {
+				t.disconnect = &Disconnect{Code: disconnectBadProtocol, Reason: "decode error", Reconnect: false}
+			}
+			return
+		}
+		if reply == nil {
+			continue
+		}
+		t.emulation.handleReply(reply)
+		select {
+		case <-t.closeCh:
+			return
+		case t.replyCh <- reply:
+		}
+	}
+}
+
+func (t *emulationTransport) Read() (*protocol.Reply, *Disconnect, error) {
+	reply, ok := <-t.replyCh
+	if !ok {
+		return nil, t.disconnect, io.EOF
+	}
+	return reply, nil, nil
+}
+
+// decodeError marks errors of decoding data received from a server.
+type decodeError struct {
+	err error
+}
+
+func (e decodeError) Error() string {
+	return fmt.Sprintf("decode error: %v", e.err)
+}
+
+func doStreamRequest(client *http.Client, req *http.Request, header http.Header) (io.ReadCloser, error) {
+	for k, v := range header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = v
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error open stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, withRetryAfter(fmt.Errorf("wrong status code while connecting to server: %d", resp.StatusCode), resp)
+	}
+	return resp.Body, nil
+}
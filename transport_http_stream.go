@@ -0,0 +1,71 @@
+package centrifuge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+
+	"github.com/centrifugal/protocol"
+)
+
+// NewHTTPStreamTransport is a TransportFactory which connects to a server over
+// HTTP-streaming with bidirectional emulation: connect command is sent in a
+// body of POST request which response streams replies from a server, all
+// subsequent commands are sent over emulation endpoint. Client uses it by
+// default for http:// and https:// endpoints.
+func NewHTTPStreamTransport(endpoint string, protocolType protocol.Type, config Config) (Transport, error) {
+	return newHTTPStreamTransport(endpoint, protocolType, config, transportOptions{})
+}
+
+func newHTTPStreamTransport(endpoint string, protocolType protocol.Type, config Config, opts transportOptions) (Transport, error) {
+	dial := func(client *http.Client) streamDialer {
+		return func(ctx context.Context, data []byte) (io.ReadCloser, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", contentType(protocolType))
+			return doStreamRequest(client, req, config.Header)
+		}
+	}
+	return newEmulationTransport(endpoint, protocolType, config, opts, dial, func(r *bufio.Reader) (*protocol.Reply, error) {
+		return readHTTPStreamReply(protocolType, r)
+	})
+}
+
+// readHTTPStreamReply reads one Reply from stream. JSON replies are separated
+// with new line, Protobuf replies are prefixed with varint length. It returns
+// nil Reply for empty JSON lines.
+func readHTTPStreamReply(protocolType protocol.Type, r *bufio.Reader) (*protocol.Reply, error) {
+	if protocolType == protocol.TypeJSON {
+		line, err := r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			return nil, nil
+		}
+		reply, err := newReplyDecoder(protocolType, line).Decode()
+		if err != nil {
+			return nil, decodeError{err}
+		}
+		return reply, nil
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	var reply protocol.Reply
+	if err := reply.UnmarshalVT(data); err != nil {
+		return nil, decodeError{err}
+	}
+	return &reply, nil
+}
@@ -0,0 +1,85 @@
+package centrifuge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/centrifugal/protocol"
+)
+
+// NewSSETransport is a TransportFactory which connects to a server over
+// Server-Sent Events (EventSource) with bidirectional emulation: connect
+// command is passed in cf_connect URL param of a GET request which response
+// is a text/event-stream of replies, all subsequent commands are sent over
+// emulation endpoint. Only JSON protocol is supported by SSE transport.
+func NewSSETransport(endpoint string, protocolType protocol.Type, config Config) (Transport, error) {
+	return newSSETransport(endpoint, protocolType, config, transportOptions{})
+}
+
+func newSSETransport(endpoint string, protocolType protocol.Type, config Config, opts transportOptions) (Transport, error) {
+	if protocolType != protocol.TypeJSON {
+		return nil, errors.New("SSE transport only supports JSON protocol")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	dial := func(client *http.Client) streamDialer {
+		return func(ctx context.Context, data []byte) (io.ReadCloser, error) {
+			streamURL := *u
+			query := streamURL.Query()
+			query.Set("cf_connect", string(data))
+			streamURL.RawQuery = query.Encode()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL.String(), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", "text/event-stream")
+			req.Header.Set("Cache-Control", "no-cache")
+			return doStreamRequest(client, req, config.Header)
+		}
+	}
+	return newEmulationTransport(endpoint, protocolType, config, opts, dial, readSSEReply)
+}
+
+// readSSEReply reads one event from text/event-stream and decodes Reply from
+// its data. It returns nil Reply for events without data.
+func readSSEReply(r *bufio.Reader) (*protocol.Reply, error) {
+	var data []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			// Empty line dispatches event.
+			break
+		}
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			// Comments and event, id, retry fields are not used.
+			continue
+		}
+		value := bytes.TrimPrefix(line[len("data:"):], []byte(" "))
+		if data != nil {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	reply, err := newReplyDecoder(protocol.TypeJSON, data).Decode()
+	if err != nil {
+		return nil, decodeError{err}
+	}
+	return reply, nil
+}
@@ -1,9 +1,11 @@
 package centrifuge
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -15,10 +17,10 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-func extractDisconnectWebsocket(err error) *disconnect {
+func extractDisconnectWebsocket(err error) *Disconnect {
 	if err != nil {
 		if closeErr, ok := err.(*websocket.CloseError); ok {
-			var d disconnect
+			var d Disconnect
 			err := json.Unmarshal([]byte(closeErr.Text), &d)
 			if err == nil {
 				return &d
@@ -37,7 +39,7 @@ func extractDisconnectWebsocket(err error) *disconnect {
 						code = connectingTransportClosed
 					}
 				}
-				return &disconnect{
+				return &Disconnect{
 					Code:      code,
 					Reason:    reason,
 					Reconnect: reconnect,
@@ -48,6 +50,10 @@ func extractDisconnectWebsocket(err error) *disconnect {
 	return nil
 }
 
+// defaultWriteBatchMaxSize is a maximum number of commands in one frame when
+// write batching enabled and WriteBatchMaxSize not set.
+const defaultWriteBatchMaxSize = 64
+
 type websocketTransport struct {
 	mu             sync.Mutex
 	conn           *websocket.Conn
@@ -55,9 +61,15 @@ type websocketTransport struct {
 	commandEncoder protocol.CommandEncoder
 	replyCh        chan *protocol.Reply
 	config         websocketConfig
-	disconnect     *disconnect
+	disconnect     *Disconnect
 	closed         bool
 	closeCh        chan struct{}
+
+	// Write batching state, used when WriteBatchInterval is set.
+	batch        bytes.Buffer
+	batchSize    int
+	batchTimer   *time.Timer
+	batchTimeout time.Duration
 }
 
 // websocketConfig configures Websocket transport.
@@ -86,9 +98,33 @@ type websocketConfig struct {
 
 	// Header specifies custom HTTP Header to send.
 	Header http.Header
+
+	// WriteBatchInterval enables write batching: commands are collected during
+	// the interval and then written to connection in one frame.
+	WriteBatchInterval time.Duration
+
+	// WriteBatchMaxSize is a maximum number of commands in one frame. When
+	// reached batch is written immediately.
+	WriteBatchMaxSize int
 }
 
-func newWebsocketTransport(url string, protocolType protocol.Type, config websocketConfig) (transport, error) {
+// NewWebsocketTransport is a TransportFactory which connects to a server over
+// Websocket. Client uses it by default.
+func NewWebsocketTransport(endpoint string, protocolType protocol.Type, config Config) (Transport, error) {
+	return newWebsocketTransport(endpoint, protocolType, websocketConfig{
+		NetDialContext:    config.NetDialContext,
+		TLSConfig:         config.TLSConfig,
+		HandshakeTimeout:  config.HandshakeTimeout,
+		EnableCompression: config.EnableCompression,
+		CookieJar:         config.CookieJar,
+		Header:            config.Header,
+
+		WriteBatchInterval: config.WriteBatchInterval,
+		WriteBatchMaxSize:  config.WriteBatchMaxSize,
+	})
+}
+
+func newWebsocketTransport(url string, protocolType protocol.Type, config websocketConfig) (Transport, error) {
 	wsHeaders := config.Header
 
 	dialer := &websocket.Dialer{}
@@ -106,7 +142,8 @@ func newWebsocketTransport(url string, protocolType protocol.Type, config websoc
 
 	conn, resp, err := dialer.Dial(url, wsHeaders)
 	if err != nil {
-		return nil, fmt.Errorf("error dial: %v", err)
+		// Response is available in case of failed handshake.
+		return nil, withRetryAfter(fmt.Errorf("error dial: %v", err), resp)
 	}
 	if resp.StatusCode != http.StatusSwitchingProtocols {
 		return nil, fmt.Errorf("wrong status code while connecting to server: %d", resp.StatusCode)
@@ -132,6 +169,9 @@ func (t *websocketTransport) Close() error {
 	}
 	t.closed = true
 	close(t.closeCh)
+	if t.batchTimer != nil {
+		t.batchTimer.Stop()
+	}
 	_ = t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
 	return t.conn.Close()
 }
@@ -157,7 +197,7 @@ func (t *websocketTransport) reader() {
 					if err == io.EOF {
 						break loop
 					}
-					t.disconnect = &disconnect{Code: disconnectBadProtocol, Reason: "decode error", Reconnect: false}
+					t.disconnect = &Disconnect{Code: disconnectBadProtocol, Reason: "decode error", Reconnect: false}
 					return
 				}
 				select {
@@ -178,12 +218,79 @@ func (t *websocketTransport) Write(cmd *protocol.Command, timeout time.Duration)
 	if err != nil {
 		return err
 	}
+	if t.config.WriteBatchInterval > 0 {
+		return t.writeBatched(data, timeout)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.writeData(data, timeout)
 }
 
-func (t *websocketTransport) writeData(data []byte, timeout time.Duration) error {
+// writeBatched adds encoded command to the current batch. Batch is flushed
+// after WriteBatchInterval or as soon as it reaches WriteBatchMaxSize.
+func (t *websocketTransport) writeBatched(data []byte, timeout time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errors.New("transport closed")
+	}
+	if t.batchSize > 0 && t.protocolType == protocol.TypeJSON {
+		// JSON commands in one frame are separated by new line, Protobuf
+		// commands are already length-delimited.
+		t.batch.WriteByte('\n')
+	}
+	t.batch.Write(data)
+	t.batchSize++
+	if timeout > t.batchTimeout {
+		t.batchTimeout = timeout
+	}
+	maxSize := t.config.WriteBatchMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultWriteBatchMaxSize
+	}
+	if t.batchSize >= maxSize {
+		if t.batchTimer != nil {
+			t.batchTimer.Stop()
+			t.batchTimer = nil
+		}
+		return t.flushBatch()
+	}
+	if t.batchTimer == nil {
+		t.batchTimer = time.AfterFunc(t.config.WriteBatchInterval, t.flushByTimer)
+	}
+	return nil
+}
+
+func (t *websocketTransport) flushByTimer() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	t.batchTimer = nil
+	if t.closed {
+		return
+	}
+	if err := t.flushBatch(); err != nil {
+		// Nobody waits for this write, so close connection to let reader
+		// exit and Client reconnect.
+		_ = t.conn.Close()
+	}
+}
+
+// Lock must be held outside.
+func (t *websocketTransport) flushBatch() error {
+	if t.batchSize == 0 {
+		return nil
+	}
+	data := make([]byte, t.batch.Len())
+	copy(data, t.batch.Bytes())
+	timeout := t.batchTimeout
+	t.batch.Reset()
+	t.batchSize = 0
+	t.batchTimeout = 0
+	return t.writeData(data, timeout)
+}
+
+// Lock must be held outside.
+func (t *websocketTransport) writeData(data []byte, timeout time.Duration) error {
 	if timeout > 0 {
 		_ = t.conn.SetWriteDeadline(time.Now().Add(timeout))
 	}
@@ -200,7 +307,7 @@ func (t *websocketTransport) writeData(data []byte, timeout time.Duration) error
 	return err
 }
 
-func (t *websocketTransport) Read() (*protocol.Reply, *disconnect, error) {
+func (t *websocketTransport) Read() (*protocol.Reply, *Disconnect, error) {
 	reply, ok := <-t.replyCh
 	if !ok {
 		return nil, t.disconnect, io.EOF
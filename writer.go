@@ -0,0 +1,89 @@
+package centrifuge
+
+import (
+	"sync"
+	"time"
+
+	"github.com/centrifugal/protocol"
+)
+
+// OverflowPolicy defines what Client does when outbound queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks caller until there is a space in outbound queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop drops command and reports ErrOutboundQueueFull over
+	// error event. Caller waiting for a reply gets ErrTimeout.
+	OverflowDrop
+	// OverflowError returns ErrOutboundQueueFull to caller.
+	OverflowError
+)
+
+// writer writes commands to Transport from a single goroutine so callers
+// never wait for network.
+type writer struct {
+	transport Transport
+	timeout   time.Duration
+	policy    OverflowPolicy
+	queue     chan *protocol.Command
+	onError   func(error)
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func newWriter(t Transport, size int, policy OverflowPolicy, timeout time.Duration, onError func(error)) *writer {
+	w := &writer{
+		transport: t,
+		timeout:   timeout,
+		policy:    policy,
+		queue:     make(chan *protocol.Command, size),
+		onError:   onError,
+		closeCh:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *writer) run() {
+	for {
+		select {
+		case <-w.closeCh:
+			return
+		case cmd := <-w.queue:
+			if err := w.transport.Write(cmd, w.timeout); err != nil {
+				// Release callers blocked on a full queue, nobody reads it
+				// anymore.
+				w.close()
+				w.onError(err)
+				return
+			}
+		}
+	}
+}
+
+// enqueue adds command to outbound queue according to overflow policy.
+func (w *writer) enqueue(cmd *protocol.Command) error {
+	if w.policy == OverflowBlock {
+		select {
+		case w.queue <- cmd:
+			return nil
+		case <-w.closeCh:
+			return ErrClientDisconnected
+		}
+	}
+	select {
+	case w.queue <- cmd:
+		return nil
+	case <-w.closeCh:
+		return ErrClientDisconnected
+	default:
+		return ErrOutboundQueueFull
+	}
+}
+
+func (w *writer) close() {
+	w.closeOnce.Do(func() {
+		close(w.closeCh)
+	})
+}